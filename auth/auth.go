@@ -0,0 +1,113 @@
+// Package auth decides whether a player connecting to the server is who they say they are.
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Nightgunner5/stuzzd/config"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var ErrNotVerified = errors.New("auth: username not verified")
+
+type Authenticator interface {
+	// The server ID sent to the client in the handshake. The client passes it to the session server when it
+	// joins, and Verify is given it again when the player logs in.
+	ServerID(token uint64) string
+
+	// Returns nil if the player has proven they own the username.
+	Verify(username, serverID string) error
+}
+
+const DefaultSessionServerURL = "http://session.minecraft.net/game/checkserver.jsp"
+
+// Checks usernames against a legacy session server.
+type SessionServer struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewSessionServer(url string) *SessionServer {
+	return &SessionServer{
+		URL:    url,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *SessionServer) ServerID(token uint64) string {
+	return fmt.Sprintf("%016x", token)
+}
+
+func (s *SessionServer) Verify(username, serverID string) error {
+	resp, err := s.Client.Get(s.URL + "?user=" + url.QueryEscape(username) + "&serverId=" + url.QueryEscape(serverID))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("auth: session server returned %s", resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64))
+	if err != nil {
+		return err
+	}
+	if string(body) != "YES" {
+		return ErrNotVerified
+	}
+	return nil
+}
+
+// Trusts whatever username the client sends. Only use this on servers that are not reachable from the internet.
+type Offline struct{}
+
+func (Offline) ServerID(token uint64) string {
+	// Tells the client not to contact the session server.
+	return "-"
+}
+
+func (Offline) Verify(username, serverID string) error {
+	return nil
+}
+
+// An Authenticator for tests. Usernames in Verified pass; everyone else gets Err, or ErrNotVerified if Err is nil.
+type Fake struct {
+	Verified map[string]bool
+	Err      error
+}
+
+func (f *Fake) ServerID(token uint64) string {
+	return fmt.Sprintf("%016x", token)
+}
+
+func (f *Fake) Verify(username, serverID string) error {
+	if f.Verified[username] {
+		return nil
+	}
+	if f.Err != nil {
+		return f.Err
+	}
+	return ErrNotVerified
+}
+
+// Returns the Authenticator selected by config.Config.AuthMode.
+func FromConfig() Authenticator {
+	switch config.Config.AuthMode {
+	case "offline":
+		log.Print("Running in offline mode. Anyone can connect with any username.")
+		return Offline{}
+	case "online", "":
+		if config.Config.SessionServerURL == "" {
+			return NewSessionServer(DefaultSessionServerURL)
+		}
+		return NewSessionServer(config.Config.SessionServerURL)
+	}
+	log.Fatalf("Unknown AuthMode %q (expected \"online\" or \"offline\")", config.Config.AuthMode)
+	panic("unreachable")
+}
@@ -0,0 +1,253 @@
+// Package bans keeps people off the server. The files are in the same format as the ones vanilla Minecraft 1.3
+// uses, so they can be copied between servers.
+package bans
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const timeFormat = "2006-01-02 15:04:05 -0700"
+
+type Entry struct {
+	// A username, an IP address or a CIDR range like 10.0.0.0/8.
+	Name    string
+	Created time.Time
+	Source  string
+
+	// The ban ends at this time. A zero time means the ban is permanent.
+	Expires time.Time
+	Reason  string
+}
+
+func (e *Entry) Expired() bool {
+	return !e.Expires.IsZero() && time.Now().After(e.Expires)
+}
+
+// Describes the ban for a kick message.
+func (e *Entry) String() string {
+	s := e.Reason
+	if !e.Expires.IsZero() {
+		s += fmt.Sprintf(" (until %s)", e.Expires.Format("2006-01-02 15:04 MST"))
+	}
+	return s
+}
+
+type List struct {
+	lock    sync.Mutex
+	file    string
+	entries map[string]*Entry // Keyed by lowercase name
+}
+
+var (
+	Players = Load("banned-players.txt")
+	IPs     = Load("banned-ips.txt")
+)
+
+// Reads a vanilla-style ban list. A missing file is treated as an empty list.
+func Load(file string) *List {
+	l := &List{file: file, entries: make(map[string]*Entry)}
+
+	f, err := os.Open(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Print("While trying to load ", file, ": ", err)
+		}
+		return l
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadString('\n')
+		if e := parseEntry(line); e != nil {
+			l.entries[strings.ToLower(e.Name)] = e
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Print("While trying to load ", file, ": ", err)
+			break
+		}
+	}
+	return l
+}
+
+// Parses a line like "name|2012-08-05 13:23:00 -0700|Server|Forever|Banned by an operator."
+func parseEntry(line string) *Entry {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+
+	fields := strings.SplitN(line, "|", 5)
+	e := &Entry{Name: strings.TrimSpace(fields[0]), Source: "(Unknown)", Reason: "Banned by an operator."}
+	if len(fields) > 1 {
+		e.Created, _ = time.Parse(timeFormat, strings.TrimSpace(fields[1]))
+	}
+	if len(fields) > 2 {
+		e.Source = strings.TrimSpace(fields[2])
+	}
+	if len(fields) > 3 && strings.TrimSpace(fields[3]) != "Forever" {
+		e.Expires, _ = time.Parse(timeFormat, strings.TrimSpace(fields[3]))
+	}
+	if len(fields) > 4 {
+		e.Reason = strings.TrimSpace(fields[4])
+	}
+	return e
+}
+
+func (e *Entry) line() string {
+	expires := "Forever"
+	if !e.Expires.IsZero() {
+		expires = e.Expires.Format(timeFormat)
+	}
+	return strings.Join([]string{e.Name, e.Created.Format(timeFormat), e.Source, expires, e.Reason}, "|")
+}
+
+// Returns the ban for name, or nil if there isn't one or it has expired.
+func (l *List) Get(name string) *Entry {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	e, ok := l.entries[strings.ToLower(name)]
+	if !ok || e.Expired() {
+		return nil
+	}
+	return e
+}
+
+// Returns every ban that has not expired, sorted by name.
+func (l *List) Entries() []*Entry {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	entries := make([]*Entry, 0, len(l.entries))
+	for _, e := range l.entries {
+		if !e.Expired() {
+			entries = append(entries, e)
+		}
+	}
+	sort.Sort(byName(entries))
+	return entries
+}
+
+type byName []*Entry
+
+func (s byName) Len() int           { return len(s) }
+func (s byName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+
+// Adds or replaces a ban and saves the list.
+func (l *List) Add(e *Entry) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if e.Created.IsZero() {
+		e.Created = time.Now()
+	}
+	l.entries[strings.ToLower(e.Name)] = e
+	return l.save()
+}
+
+// Removes a ban and saves the list. Returns false if there was no ban to remove.
+func (l *List) Remove(name string) (bool, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if _, ok := l.entries[strings.ToLower(name)]; !ok {
+		return false, nil
+	}
+	delete(l.entries, strings.ToLower(name))
+	return true, l.save()
+}
+
+// The caller must hold the list's lock.
+func (l *List) save() error {
+	f, err := os.Create(l.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "# Updated %s by StuzzD\n", time.Now().Format("1/2/06 3:04 PM"))
+	fmt.Fprintln(w, "# victim name | ban date | banned by | banned until | reason")
+	fmt.Fprintln(w)
+	for _, e := range l.entries {
+		if !e.Expired() {
+			fmt.Fprintln(w, e.line())
+		}
+	}
+	return w.Flush()
+}
+
+// Returns the ban covering ip, either by address or by CIDR range, or nil if it is not banned.
+func IPBan(ip net.IP) *Entry {
+	if e := IPs.Get(ip.String()); e != nil {
+		return e
+	}
+
+	for _, e := range IPs.Entries() {
+		if e.CoversIP(ip) {
+			return e
+		}
+	}
+	return nil
+}
+
+// Reports whether an IP ban entry's address or CIDR range includes ip.
+func (e *Entry) CoversIP(ip net.IP) bool {
+	if banned := net.ParseIP(e.Name); banned != nil {
+		return banned.Equal(ip)
+	}
+	_, network, err := net.ParseCIDR(e.Name)
+	return err == nil && network.Contains(ip)
+}
+
+var durationUnits = map[byte]time.Duration{
+	's': time.Second,
+	'm': time.Minute,
+	'h': time.Hour,
+	'd': 24 * time.Hour,
+	'w': 7 * 24 * time.Hour,
+}
+
+// Parses a ban length like "30m", "12h" or "1w2d". The units are s, m, h, d and w.
+func ParseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, fmt.Errorf("bans: empty duration")
+	}
+
+	var total time.Duration
+	for s != "" {
+		i := 0
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		if i == 0 || i == len(s) {
+			return 0, fmt.Errorf("bans: invalid duration %q", s)
+		}
+		unit, ok := durationUnits[s[i]]
+		if !ok {
+			return 0, fmt.Errorf("bans: unknown unit %q in duration", s[i])
+		}
+		n, err := strconv.Atoi(s[:i])
+		if err != nil {
+			return 0, err
+		}
+		total += time.Duration(n) * unit
+		s = s[i+1:]
+	}
+	return total, nil
+}
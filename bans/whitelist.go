@@ -0,0 +1,110 @@
+package bans
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The players allowed on the server when the whitelist is turned on. Stored in white-list.txt, one name per line.
+var Whitelist = loadWhitelist("white-list.txt")
+
+type whitelist struct {
+	lock  sync.Mutex
+	file  string
+	names map[string]bool // Lowercase
+}
+
+func loadWhitelist(file string) *whitelist {
+	w := &whitelist{file: file, names: make(map[string]bool)}
+
+	f, err := os.Open(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Print("While trying to load ", file, ": ", err)
+		}
+		return w
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			w.names[strings.ToLower(line)] = true
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Print("While trying to load ", file, ": ", err)
+			break
+		}
+	}
+	return w
+}
+
+func (w *whitelist) Contains(name string) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.names[strings.ToLower(name)]
+}
+
+// Returns false if the name was already on the whitelist.
+func (w *whitelist) Add(name string) (bool, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.names[strings.ToLower(name)] {
+		return false, nil
+	}
+	w.names[strings.ToLower(name)] = true
+	return true, w.save()
+}
+
+// Returns false if the name was not on the whitelist.
+func (w *whitelist) Remove(name string) (bool, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if !w.names[strings.ToLower(name)] {
+		return false, nil
+	}
+	delete(w.names, strings.ToLower(name))
+	return true, w.save()
+}
+
+// Returns every name on the whitelist, sorted.
+func (w *whitelist) Names() []string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	names := make([]string, 0, len(w.names))
+	for name := range w.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// The caller must hold the whitelist's lock.
+func (w *whitelist) save() error {
+	f, err := os.Create(w.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	out := bufio.NewWriter(f)
+	for name := range w.names {
+		fmt.Fprintln(out, name)
+	}
+	return out.Flush()
+}
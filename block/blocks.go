@@ -155,6 +155,59 @@ func (b BlockType) SemiPassable() bool {
 	return false
 }
 
+// Blocks that fill their whole space and can't be seen through, which vanilla calls normal cubes. Players
+// suffocate when their head is inside one.
+func (b BlockType) Opaque() bool {
+	if b.Passable() || b.SemiPassable() {
+		return false
+	}
+	switch b {
+	case Leaves, Glass, Ice, Glowstone, Cactus, MobSpawner, Chest, Trapdoor, Farm,
+		PistonBase, PistonBaseSticky, PistonExtension, PistonMovingPiece, DragonEgg, EndPortalFrame:
+		return false
+	}
+	return true
+}
+
+// How much light a block gives off, from 0 to 15.
+func (b BlockType) Light() uint8 {
+	switch b {
+	case Lava, StationaryLava, Fire, Glowstone, JackOLantern, EndPortal, RedstoneLampOn:
+		return 15
+	case Torch:
+		return 14
+	case FurnaceBurning:
+		return 13
+	case NetherPortal:
+		return 11
+	case RedstoneRepeaterOn:
+		return 9
+	case RedstoneTorchOn:
+		return 7
+	case BrownMushroom, BrewingStand, DragonEgg:
+		return 1
+	}
+	return 0
+}
+
+func (b BlockType) IsWater() bool {
+	return b == Water || b == StationaryWater
+}
+
+func (b BlockType) IsLava() bool {
+	return b == Lava || b == StationaryLava
+}
+
+// Blocks that are overwritten when a block is placed in their space.
+func (b BlockType) Replaceable() bool {
+	switch b {
+	case Air, Water, StationaryWater, Lava, StationaryLava, LongGrass,
+		DeadBush, Fire, Snow, Vines:
+		return true
+	}
+	return false
+}
+
 func (b BlockType) ItemDrop() int16 {
 	switch b {
 	case Grass:
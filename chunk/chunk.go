@@ -115,6 +115,46 @@ func (c *Chunk) recalculateHeight(x, z int32) {
 	c.HeightMap[(z&0xF)<<4|(x&0xF)] = 0
 }
 
+// The light from the sky at a block, from 0 to 15. Nighttime isn't taken into account.
+func (c *Chunk) GetSkyLight(x, y, z int32) uint8 {
+	if y < 0 {
+		return 0
+	}
+	if y > MAX_HEIGHT {
+		return 15
+	}
+	if x>>4 != c.X || z>>4 != c.Z {
+		panic(fmt.Sprintf("GetSkyLight() called on chunk %d, %d but should have been called on chunk %d, %d!", c.X, c.Z, x>>4, z>>4))
+	}
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	if !c.Sections.Has(byte(y >> 4)) {
+		// Sections that are all air aren't stored. Treat them as open to the sky.
+		return 15
+	}
+	return c.Sections.Get(byte(y>>4)).SkyLight.Get(x, y, z)
+}
+
+// The light from glowing blocks at a block, from 0 to 15.
+func (c *Chunk) GetBlockLight(x, y, z int32) uint8 {
+	if y < 0 || y > MAX_HEIGHT {
+		return 0
+	}
+	if x>>4 != c.X || z>>4 != c.Z {
+		panic(fmt.Sprintf("GetBlockLight() called on chunk %d, %d but should have been called on chunk %d, %d!", c.X, c.Z, x>>4, z>>4))
+	}
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	if !c.Sections.Has(byte(y >> 4)) {
+		return 0
+	}
+	return c.Sections.Get(byte(y>>4)).BlockLight.Get(x, y, z)
+}
+
 func (c *Chunk) GetData(x, y, z int32) uint8 {
 	if y < 0 || y > MAX_HEIGHT {
 		return 0
@@ -193,6 +233,19 @@ func (c *Chunk) dirtyGeneric() {
 	c.NeedsSave = true
 }
 
+// Calls marshal with the chunk locked if the chunk has changed since it was last saved, and marks it as saved.
+// Returns false without calling marshal if there is nothing to save.
+func (c *Chunk) MarshalIfChanged(marshal func() error) (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if !c.NeedsSave {
+		return false, nil
+	}
+	c.NeedsSave = false
+	return true, marshal()
+}
+
 func (c *Chunk) InitLighting() {
 	c.lock.Lock()
 	defer c.lock.Unlock()
@@ -242,10 +295,67 @@ func (c *Chunk) processLighting() {
 		}
 	}
 
+	c.processBlockLight()
+
 	c.lightingDirty = false
 	c.dirtyGeneric()
 }
 
+// Spreads the light from glowing blocks, losing one level per block and stopping at opaque blocks. Light doesn't
+// reach into neighbouring chunks.
+func (c *Chunk) processBlockLight() {
+	type position struct{ x, y, z int32 }
+	index := func(p position) int {
+		return int(p.y)<<8 | int(p.z)<<4 | int(p.x)
+	}
+
+	var blocks [16 * 256 * 16]block.BlockType
+	var light [16 * 256 * 16]uint8
+	var queue []position
+	for _, section := range c.Sections {
+		for y := int32(section.Y) << 4; y < int32(section.Y)<<4+16; y++ {
+			for z := int32(0); z < 16; z++ {
+				for x := int32(0); x < 16; x++ {
+					p := position{x, y, z}
+					blocks[index(p)] = section.Blocks.Get(x, y, z)
+					if l := blocks[index(p)].Light(); l > 0 {
+						light[index(p)] = l
+						queue = append(queue, p)
+					}
+				}
+			}
+		}
+	}
+
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		l := light[index(p)]
+		if l <= 1 {
+			continue
+		}
+		for _, n := range [...]position{{p.x - 1, p.y, p.z}, {p.x + 1, p.y, p.z}, {p.x, p.y - 1, p.z}, {p.x, p.y + 1, p.z}, {p.x, p.y, p.z - 1}, {p.x, p.y, p.z + 1}} {
+			if n.x < 0 || n.x > 15 || n.y < 0 || n.y > MAX_HEIGHT || n.z < 0 || n.z > 15 {
+				continue
+			}
+			if i := index(n); light[i] < l-1 && !blocks[i].Opaque() {
+				light[i] = l - 1
+				queue = append(queue, n)
+			}
+		}
+	}
+
+	for _, section := range c.Sections {
+		for y := int32(section.Y) << 4; y < int32(section.Y)<<4+16; y++ {
+			for z := int32(0); z < 16; z++ {
+				for x := int32(0); x < 16; x++ {
+					section.BlockLight.Set(x, y, z, light[index(position{x, y, z})])
+				}
+			}
+		}
+	}
+}
+
 func (c *Chunk) Packet() []byte {
 	c.lock.RLock()
 	if !c.packetDirty && c.packet != nil {
@@ -313,25 +423,53 @@ func (c *Chunk) SpawnEntity(e Entity) {
 	if _, ok := e["uuid"]; !ok {
 		e["uuid"] = uuid.New()
 	}
+	e.ID() // Assign the ID now so that it isn't written while other goroutines are reading the entity.
 
 	c.Entities = append(c.Entities, e)
 
 	c.NeedsSave = true
 }
 
+// Gives every entity in the chunk a UUID and entity ID. Entities saved by other servers may not have them.
+func (c *Chunk) AssignEntityIDs() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, e := range c.Entities {
+		if _, ok := e["uuid"].(string); !ok {
+			e["uuid"] = uuid.New()
+		}
+		e.ID()
+	}
+}
+
+// Returns a copy of the chunk's entity list.
+func (c *Chunk) GetEntities() []Entity {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	entities := make([]Entity, len(c.Entities))
+	copy(entities, c.Entities)
+	return entities
+}
+
+// Runs f while holding the chunk's write lock so that it can safely modify the chunk's entities.
+func (c *Chunk) UpdateEntities(f func()) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	f()
+
+	c.NeedsSave = true
+}
+
 func (c *Chunk) DespawnEntity(e Entity) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
 	for i, ent := range c.Entities {
 		if ent["uuid"].(string) == e["uuid"].(string) {
-			if i == 0 {
-				c.Entities = c.Entities[1:]
-			} else if i == len(c.Entities)-1 {
-				c.Entities = c.Entities[:len(c.Entities)-1]
-			} else {
-				c.Entities = append(c.Entities[:i-1], c.Entities[i+1:]...)
-			}
+			c.Entities = append(c.Entities[:i], c.Entities[i+1:]...)
 			c.NeedsSave = true
 			return
 		}
@@ -361,17 +499,72 @@ func (e Entity) ID() int32 {
 	return e["EID"].(int32)
 }
 
+func (e Entity) Position() (x, y, z float64) {
+	pos := e["Pos"].([]float64)
+	return pos[0], pos[1], pos[2]
+}
+
+// Entity data must only be modified while holding the chunk's lock. See UpdateEntities.
+func (e Entity) SetPosition(x, y, z float64) {
+	e["Pos"] = []float64{x, y, z}
+}
+
+func (e Entity) Motion() (x, y, z float64) {
+	if motion, ok := e["Motion"].([]float64); ok && len(motion) == 3 {
+		return motion[0], motion[1], motion[2]
+	}
+	return 0, 0, 0
+}
+
+func (e Entity) SetMotion(x, y, z float64) {
+	e["Motion"] = []float64{x, y, z}
+}
+
+func (e Entity) OnGround() bool {
+	onGround, _ := e["OnGround"].(int8)
+	return onGround != 0
+}
+
+func (e Entity) SetOnGround(onGround bool) {
+	if onGround {
+		e["OnGround"] = int8(1)
+	} else {
+		e["OnGround"] = int8(0)
+	}
+}
+
+// The number of ticks the entity has existed for.
+func (e Entity) Age() int16 {
+	age, _ := e["Age"].(int16)
+	return age
+}
+
+func (e Entity) SetAge(age int16) {
+	e["Age"] = age
+}
+
 func (e Entity) Type() string {
 	return e["id"].(string)
 }
 
-func (e Entity) SpawnPacket(w io.Writer) {
-	switch e.Type() {
-	case "Item":
-		e.ItemDrop().SpawnPacket(w)
-	default:
-		panic("Unhandled entity type: " + e.Type())
+// Reports whether the server knows how to show the entity to clients. Worlds made by vanilla can have entities
+// it doesn't handle yet, such as paintings, arrows, minecarts and wolves.
+func (e Entity) Spawnable() bool {
+	return e.ItemDrop() != nil || e.Mob() != nil
+}
+
+// Writes the packet that shows the entity to a client. Returns false without writing anything if the entity
+// isn't Spawnable.
+func (e Entity) SpawnPacket(w io.Writer) bool {
+	if item := e.ItemDrop(); item != nil {
+		item.SpawnPacket(w)
+		return true
+	}
+	if mob := e.Mob(); mob != nil {
+		mob.SpawnPacket(w)
+		return true
 	}
+	return false
 }
 
 type TileEntity map[string]interface{}
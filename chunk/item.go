@@ -27,6 +27,7 @@ func NewItemDrop(x, y, z float64, item *player.InventoryItem) ItemDrop {
 		"OnGround":     int8(1),
 		"Health":       int16(5),
 		"Age":          int16(0),
+		"PickupDelay":  int16(10),
 		"Item": map[string]interface{}{
 			"id":     item.Type,
 			"Damage": item.Damage,
@@ -48,6 +49,22 @@ func (i ItemDrop) Damage() int16 {
 	return i["Item"].(map[string]interface{})["Damage"].(int16)
 }
 
+func (i ItemDrop) SetCount(count int8) {
+	i["Item"].(map[string]interface{})["Count"] = count
+}
+
+// The number of ticks before a player can pick the item up.
+func (i ItemDrop) PickupDelay() int16 {
+	if delay, ok := i["PickupDelay"].(int16); ok {
+		return delay
+	}
+	return 0
+}
+
+func (i ItemDrop) SetPickupDelay(delay int16) {
+	i["PickupDelay"] = delay
+}
+
 func (i ItemDrop) SpawnPacket(w io.Writer) {
 	binary.Write(w, binary.BigEndian, uint8(0x15))
 	binary.Write(w, binary.BigEndian, Entity(i).ID())
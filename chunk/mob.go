@@ -0,0 +1,195 @@
+package chunk
+
+import (
+	"github.com/Nightgunner5/stuzzd/protocol"
+	"io"
+	"math/rand"
+)
+
+type Mob Entity
+
+// The mobs the server knows about, by the ID vanilla saves them with.
+var mobTypes = map[string]protocol.MobType{
+	"Pig":     protocol.MobPig,
+	"Sheep":   protocol.MobSheep,
+	"Cow":     protocol.MobCow,
+	"Chicken": protocol.MobChicken,
+
+	"Creeper":  protocol.MobCreeper,
+	"Skeleton": protocol.MobSkeleton,
+	"Spider":   protocol.MobSpider,
+	"Zombie":   protocol.MobZombie,
+}
+
+var mobHealth = map[string]int16{
+	"Pig":     10,
+	"Sheep":   8,
+	"Cow":     10,
+	"Chicken": 4,
+
+	"Creeper":  20,
+	"Skeleton": 20,
+	"Spider":   16,
+	"Zombie":   20,
+}
+
+func (e Entity) Mob() Mob {
+	if _, ok := mobTypes[e.Type()]; ok {
+		return Mob(e)
+	}
+	return nil
+}
+
+func NewMob(mobType string, x, y, z float64, yaw float32) Mob {
+	m := Mob{
+		"id":           mobType,
+		"Pos":          []float64{x, y, z},
+		"Motion":       []float64{0, 0, 0},
+		"Rotation":     []float32{yaw, 0},
+		"FallDistance": float32(0),
+		"Fire":         int16(-1),
+		"Air":          int16(300),
+		"OnGround":     int8(1),
+		"Health":       mobHealth[mobType],
+		"HurtTime":     int16(0),
+		"DeathTime":    int16(0),
+		"AttackTime":   int16(0),
+	}
+	switch mobType {
+	case "Pig", "Sheep", "Cow", "Chicken":
+		m["Age"] = int32(0)
+	}
+	if mobType == "Sheep" {
+		m["Sheared"] = int8(0)
+		m["Color"] = int8(randomSheepColor())
+	}
+	return m
+}
+
+// Most sheep are white. The rest are black, gray, light gray, brown or (rarely) pink, as in vanilla.
+func randomSheepColor() uint8 {
+	switch n := rand.Intn(100); {
+	case n < 5:
+		return 15 // Black
+	case n < 10:
+		return 7 // Gray
+	case n < 15:
+		return 8 // Light gray
+	case n < 18:
+		return 12 // Brown
+	case rand.Intn(500) == 0:
+		return 6 // Pink
+	}
+	return 0
+}
+
+func (m Mob) Type() string {
+	return Entity(m).Type()
+}
+
+func (m Mob) Health() int16 {
+	health, _ := m["Health"].(int16)
+	return health
+}
+
+// Entity data must only be modified while holding the chunk's lock. See UpdateEntities.
+func (m Mob) SetHealth(health int16) {
+	m["Health"] = health
+}
+
+// The number of ticks until the mob can be hurt again.
+func (m Mob) HurtTime() int16 {
+	hurtTime, _ := m["HurtTime"].(int16)
+	return hurtTime
+}
+
+func (m Mob) SetHurtTime(hurtTime int16) {
+	m["HurtTime"] = hurtTime
+}
+
+// The number of ticks since the mob died, or 0 if it is alive.
+func (m Mob) DeathTime() int16 {
+	deathTime, _ := m["DeathTime"].(int16)
+	return deathTime
+}
+
+func (m Mob) SetDeathTime(deathTime int16) {
+	m["DeathTime"] = deathTime
+}
+
+// The number of ticks the mob will keep burning for.
+func (m Mob) Fire() int16 {
+	fire, _ := m["Fire"].(int16)
+	return fire
+}
+
+func (m Mob) SetFire(fire int16) {
+	m["Fire"] = fire
+}
+
+func (m Mob) Yaw() float32 {
+	if rotation, ok := m["Rotation"].([]float32); ok && len(rotation) == 2 {
+		return rotation[0]
+	}
+	return 0
+}
+
+func (m Mob) SetYaw(yaw float32) {
+	m["Rotation"] = []float32{yaw, 0}
+}
+
+func (m Mob) Sheared() bool {
+	sheared, _ := m["Sheared"].(int8)
+	return sheared != 0
+}
+
+// The wool color of a sheep.
+func (m Mob) Color() uint8 {
+	color, _ := m["Color"].(int8)
+	return uint8(color) & 0x0F
+}
+
+func (m Mob) Metadata() protocol.Metadata {
+	var flags int8
+	if m.Fire() > 0 {
+		flags |= protocol.FlagOnFire
+	}
+	meta := protocol.Metadata{
+		protocol.MetaFlags: flags,
+		protocol.MetaAir:   int16(300),
+	}
+	switch m.Type() {
+	case "Pig":
+		meta[12] = int32(0) // Growing age. Negative for babies.
+		meta[16] = int8(0)  // Saddled
+	case "Sheep":
+		wool := int8(m.Color())
+		if m.Sheared() {
+			wool |= 0x10
+		}
+		meta[12] = int32(0)
+		meta[16] = wool
+	case "Cow", "Chicken":
+		meta[12] = int32(0)
+	case "Creeper":
+		meta[16] = int8(-1) // Fuse: -1 when idle, 1 when about to explode
+		meta[17] = int8(0)  // Charged by lightning
+	case "Spider":
+		meta[16] = int8(0) // Climbing
+	}
+	return meta
+}
+
+func (m Mob) SpawnPacket(w io.Writer) {
+	x, y, z := Entity(m).Position()
+	w.Write(protocol.MobSpawn{
+		EID:      Entity(m).ID(),
+		Type:     mobTypes[m.Type()],
+		X:        x,
+		Y:        y,
+		Z:        z,
+		Yaw:      m.Yaw(),
+		HeadYaw:  m.Yaw(),
+		Metadata: m.Metadata(),
+	}.Packet())
+}
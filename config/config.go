@@ -4,39 +4,167 @@ import (
 	"encoding/json"
 	"log"
 	"os"
+	"sync"
+	"sync/atomic"
 )
 
+// How many ticks the server has run since it started. The world's time is kept in level.dat.
+// Only the scheduler changes it. Other goroutines read it with CurrentTick.
 var Tick uint64
 
+func CurrentTick() uint64 {
+	return atomic.LoadUint64(&Tick)
+}
+
 type Configuration struct {
 	NumSlots          uint64
 	ServerDescription string
+
+	// How many ticks the server will run back-to-back to catch up after lagging before it gives up and skips them.
+	MaxCatchUpTicks uint64
+
+	// How close, in blocks, an entity has to be to a player for the player to see it.
+	EntityTrackingRadius int32
+
+	// How many chunks out from the one they are standing in players are sent.
+	ViewDistance int32
+
+	// The most chunks sent to each player per tick.
+	ChunksPerTick int
+
+	// "online" checks usernames with the session server; "offline" trusts them.
+	AuthMode string
+
+	// The legacy session server's checkserver.jsp, or a local stand-in for it.
+	SessionServerURL string
+
+	// How many seconds the server spends saving the world when it shuts down before giving up.
+	SaveTimeout uint64
+
+	// Only let players on white-list.txt (and players with stuzzd.whitelist.bypass) join.
+	// Use WhitelistEnabled and SetWhitelist while the server is running.
+	Whitelist bool
+
+	// Players put in the admin group when permissions.json is first created. After that, /perm changes who
+	// is an admin.
+	Admins []string
+
+	// Words that are replaced with asterisks in chat. Matching ignores case.
+	ChatFilter []string
+
+	// If false, the time of day never changes.
+	DaylightCycle bool
+
+	// If false, players can't hurt each other.
+	PvP bool
+
+	// The most animals natural spawning puts in one chunk. 0 turns animal spawning off.
+	AnimalsPerChunk int
+
+	// The most hostile mobs natural spawning puts in one chunk. 0 turns monster spawning off.
+	MonstersPerChunk int
+
+	// 0 is peaceful, 1 easy, 2 normal and 3 hard. Hostile mobs don't spawn on peaceful.
+	Difficulty uint8
+
+	// The game mode new players start in: 0 is survival, 1 creative and 2 adventure.
+	DefaultGameMode int32
+
+	// Put every player in DefaultGameMode when they join, whatever mode they were in when they left.
+	ForceGameMode bool
+
+	// Sent to clients, which use it to decide how the sky and void look: "default" or "flat".
+	LevelType string
+
+	// Sent to clients, which use it to decide how the sky looks: -1 is the nether, 0 the overworld and 1 the end.
+	Dimension int32
 }
 
 var Config Configuration
 
-func (c *Configuration) Save() {
-	f, err := os.Create("stuzzd.conf")
+// True if there was no stuzzd.conf when the server started.
+var missing bool
+
+// Guards the settings commands can change while the server is running. Those settings are read and changed
+// with the functions below rather than through Config.
+var lock sync.RWMutex
+
+func (c *Configuration) Save() error {
+	lock.RLock()
+	data, err := json.MarshalIndent(c, "", "\t")
+	lock.RUnlock()
 	if err != nil {
-		log.Print(err)
+		return err
 	}
-	defer f.Close()
 
-	data, err := json.MarshalIndent(c, "", "\t")
+	f, err := os.Create("stuzzd.conf")
 	if err != nil {
-		log.Print(err)
+		return err
 	}
+	defer f.Close()
 
 	_, err = f.Write(data)
-	if err != nil {
-		log.Print(err)
+	return err
+}
+
+func WhitelistEnabled() bool {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	return Config.Whitelist
+}
+
+// Turns the whitelist on or off and saves the config.
+func SetWhitelist(enabled bool) error {
+	lock.Lock()
+	Config.Whitelist = enabled
+	lock.Unlock()
+
+	return Config.Save()
+}
+
+func CurrentDifficulty() uint8 {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	return Config.Difficulty
+}
+
+// Changes the difficulty and saves the config.
+func SetDifficulty(difficulty uint8) error {
+	lock.Lock()
+	Config.Difficulty = difficulty
+	lock.Unlock()
+
+	return Config.Save()
+}
+
+// Writes stuzzd.conf with the defaults if there wasn't one when the server started, so there is a file to edit.
+// Loading the config in init only reads, so importing this package (in tests, for example) doesn't create files.
+func SaveIfMissing() error {
+	if !missing {
+		return nil
 	}
+	return Config.Save()
 }
 
 func init() {
 	// Defaults
 	Config.NumSlots = 10
 	Config.ServerDescription = "StuzzHosting is Best Hosting"
+	Config.MaxCatchUpTicks = 20
+	Config.EntityTrackingRadius = 64
+	Config.ViewDistance = 8
+	Config.ChunksPerTick = 4
+	Config.AuthMode = "online"
+	Config.SessionServerURL = "http://session.minecraft.net/game/checkserver.jsp"
+	Config.SaveTimeout = 30
+	Config.DaylightCycle = true
+	Config.PvP = true
+	Config.AnimalsPerChunk = 2
+	Config.MonstersPerChunk = 2
+	Config.Difficulty = 0
+	Config.LevelType = "default"
 
 	// Read the file
 	f, err := os.Open("stuzzd.conf")
@@ -44,7 +172,7 @@ func init() {
 		log.Print(err)
 	}
 	if f == nil {
-		Config.Save()
+		missing = true
 		return
 	}
 	defer f.Close()
@@ -55,4 +183,7 @@ func init() {
 		// If the config file has errors, don't continue with possibly unwanted operation.
 		log.Fatal(err)
 	}
+	if Config.DefaultGameMode < 0 || Config.DefaultGameMode > 2 {
+		log.Fatalf("DefaultGameMode is %d, but it must be 0 (survival), 1 (creative) or 2 (adventure)", Config.DefaultGameMode)
+	}
 }
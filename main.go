@@ -2,9 +2,11 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"github.com/Nightgunner5/stuzzd/config"
 	"github.com/Nightgunner5/stuzzd/networking"
-	"github.com/Nightgunner5/stuzzd/protocol"
+	"github.com/Nightgunner5/stuzzd/permissions"
+	"github.com/Nightgunner5/stuzzd/scheduler"
 	"github.com/Nightgunner5/stuzzd/storage"
 	"io"
 	"log"
@@ -20,8 +22,6 @@ var flagHostPort = flag.String("hostport", ":25565", "The host and port to liste
 var flagCPUProfile = flag.String("cpuprofile", "", "write cpu profile to file")
 var flagMemProfile = flag.String("memprofile", "", "write memory profile to file")
 
-const TICK = time.Second / 20
-
 func main() {
 	flag.Parse()
 
@@ -52,6 +52,13 @@ func main() {
 
 	}
 
+	if err := config.SaveIfMissing(); err != nil {
+		log.Print("While trying to create stuzzd.conf: ", err)
+	}
+	if err := permissions.SaveIfMissing(); err != nil {
+		log.Print("While trying to create permissions.json: ", err)
+	}
+
 	os.Mkdir("world", 0755)
 	os.Mkdir("world/region", 0755)
 	os.Mkdir("world/players", 0755)
@@ -64,10 +71,16 @@ func main() {
 	}
 	log.Print("Now listening on ", *flagHostPort)
 
+	closing := make(chan struct{})
 	go func() {
 		for {
 			conn, err := ln.Accept()
 			if err != nil {
+				select {
+				case <-closing:
+					return
+				default:
+				}
 				log.Print("Error while accepting a connection: ", err)
 				continue
 			}
@@ -77,22 +90,57 @@ func main() {
 
 	go func() {
 		ch := make(chan os.Signal, 1)
-		signal.Notify(ch, syscall.SIGINT)
-		<-ch
-		log.Print("Saving ALL the data!")
-		networking.SaveAllTheThings()
-		if *flagCPUProfile != "" {
-			log.Print("Finishing up profile information...")
-			pprof.StopCPUProfile()
+		signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+		log.Print("Received ", <-ch, ", shutting down.")
+		scheduler.Stop()
+	}()
+
+	go networking.ReadConsole(os.Stdin)
+
+	scheduler.Run()
+
+	close(closing)
+	ln.Close()
+
+	log.Print("Saving ALL the data!")
+	networking.KickAll("Server is shutting down!")
+	ok := saveWorld(time.Duration(config.Config.SaveTimeout) * time.Second)
+
+	if *flagCPUProfile != "" {
+		log.Print("Finishing up profile information...")
+		pprof.StopCPUProfile()
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// Saves every loaded player and chunk, logging anything that fails. Returns false if anything could not be saved
+// before the timeout.
+func saveWorld(timeout time.Duration) bool {
+	done := make(chan []error, 1)
+	go func() {
+		errs := networking.SaveAllPlayers()
+		errs = append(errs, storage.SaveAllChunks()...)
+		if err := storage.SaveLevel(); err != nil {
+			errs = append(errs, fmt.Errorf("level.dat: %v", err))
 		}
-		os.Exit(0)
+		done <- errs
 	}()
 
-	for {
-		time.Sleep(TICK)
-		config.Tick++
-		if config.Tick%100 == 0 {
-			networking.SendToAll(protocol.TimeUpdate{Time: config.Tick})
+	select {
+	case errs := <-done:
+		for _, err := range errs {
+			log.Print("Failed to save ", err)
+		}
+		if len(errs) != 0 {
+			log.Printf("%d things could not be saved.", len(errs))
+			return false
 		}
+		log.Print("Everything was saved.")
+		return true
+	case <-time.After(timeout):
+		log.Print("Gave up saving after ", timeout, ". Some chunks or players may not have been saved.")
+		return false
 	}
 }
@@ -0,0 +1,240 @@
+package networking
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Nightgunner5/stuzzd/bans"
+	"github.com/Nightgunner5/stuzzd/config"
+	"github.com/Nightgunner5/stuzzd/permissions"
+	"github.com/Nightgunner5/stuzzd/protocol"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// Returns why the player may not join, or "" if they may.
+func loginDenied(p *_player) string {
+	if addr, ok := p.remoteAddr.(*net.TCPAddr); ok {
+		if ban := bans.IPBan(addr.IP); ban != nil {
+			return "Your IP address is banned from this server! " + ban.String()
+		}
+	}
+	if ban := bans.Players.Get(p.Username()); ban != nil {
+		return "You are banned from this server! " + ban.String()
+	}
+	if config.WhitelistEnabled() && !bans.Whitelist.Contains(p.Username()) && !permissions.Has(p.Username(), "stuzzd.whitelist.bypass") {
+		return "You are not white-listed on this server!"
+	}
+	return ""
+}
+
+// Kicks the player if loginDenied gives a reason to. Returns true if they were kicked.
+func refuseLogin(p *_player) bool {
+	reason := loginDenied(p)
+	if reason == "" {
+		return false
+	}
+	log.Print("Refusing ", p.Username(), " (", p.remoteAddr, "): ", reason)
+	p.SendPacketSync(protocol.Kick{Reason: reason})
+	return true
+}
+
+// Splits "[duration] [reason...]" into a ban expiry and reason.
+func parseBan(rest string) (expires time.Time, reason string) {
+	reason = "Banned by an operator."
+	words := strings.SplitN(rest, " ", 2)
+	if words[0] != "" {
+		if d, err := bans.ParseDuration(words[0]); err == nil {
+			expires = time.Now().Add(d)
+			words = words[1:]
+		}
+	}
+	if len(words) != 0 && words[0] != "" {
+		reason = words[0]
+	}
+	return
+}
+
+func banArgs(target string) []Arg {
+	return []Arg{{Name: target, Type: ArgWord}, {Name: "duration and reason", Type: ArgRest, Optional: true}}
+}
+
+func init() {
+	RegisterCommand(&Command{
+		Name:        "ban",
+		Description: "Keep a player off the server, optionally for a time like 30m, 12h, 7d or 2w.",
+		Permission:  "stuzzd.command.ban",
+		Args:        banArgs("player"),
+		Run: func(sender CommandSender, args Args) error {
+			name := args.String(0)
+			var rest string
+			if args.Has(1) {
+				rest = args.String(1)
+			}
+			expires, reason := parseBan(rest)
+
+			ban := &bans.Entry{Name: name, Source: sender.Username(), Expires: expires, Reason: reason}
+			if err := bans.Players.Add(ban); err != nil {
+				log.Print("While saving the ban list: ", err)
+				return errors.New("The ban could not be saved.")
+			}
+
+			if target := FindPlayer(name); target != nil {
+				go target.SendPacketSync(protocol.Kick{Reason: "You are banned from this server! " + ban.String()})
+			}
+			SendToAll(protocol.Chat{Message: fmt.Sprintf("%s has been banned by %s.", formatUsernameOffline(name), formatUsername(sender))})
+			return nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:        "ban-ip",
+		Description: "Keep an IP address, a CIDR range or an online player's address off the server.",
+		Permission:  "stuzzd.command.ban-ip",
+		Args:        banArgs("address|range|player"),
+		Run: func(sender CommandSender, args Args) error {
+			address := args.String(0)
+			if target := FindPlayer(address); target != nil {
+				tcp, ok := target.(*_player).remoteAddr.(*net.TCPAddr)
+				if !ok {
+					return errors.New("That player's address is unknown.")
+				}
+				address = tcp.IP.String()
+			} else if ip := net.ParseIP(address); ip != nil {
+				address = ip.String()
+			} else if _, network, err := net.ParseCIDR(address); err == nil {
+				address = network.String()
+			} else {
+				return UsageError(address + " is not an online player, an IP address or a CIDR range.")
+			}
+
+			var rest string
+			if args.Has(1) {
+				rest = args.String(1)
+			}
+			expires, reason := parseBan(rest)
+
+			ban := &bans.Entry{Name: address, Source: sender.Username(), Expires: expires, Reason: reason}
+			if err := bans.IPs.Add(ban); err != nil {
+				log.Print("While saving the IP ban list: ", err)
+				return errors.New("The ban could not be saved.")
+			}
+
+			for _, p := range registered.allPlayers() {
+				if addr, ok := p.(*_player).remoteAddr.(*net.TCPAddr); ok && ban.CoversIP(addr.IP) {
+					go p.SendPacketSync(protocol.Kick{Reason: "Your IP address is banned from this server! " + ban.String()})
+				}
+			}
+			sendChat(sender, ChatInfo+"Banned "+ChatPayload+address+ChatInfo+".")
+			return nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:        "pardon",
+		Aliases:     []string{"unban"},
+		Description: "Lift a player's ban.",
+		Permission:  "stuzzd.command.pardon",
+		Args:        []Arg{{Name: "player", Type: ArgWord}},
+		Run: func(sender CommandSender, args Args) error {
+			removed, err := bans.Players.Remove(args.String(0))
+			if err != nil {
+				log.Print("While saving the ban list: ", err)
+				return errors.New("The ban list could not be saved.")
+			}
+			if !removed {
+				return errors.New("That player is not banned.")
+			}
+			sendChat(sender, ChatInfo+"Pardoned "+formatUsernameOffline(args.String(0))+".")
+			return nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:        "pardon-ip",
+		Aliases:     []string{"unban-ip"},
+		Description: "Lift a ban on an IP address or range.",
+		Permission:  "stuzzd.command.pardon-ip",
+		Args:        []Arg{{Name: "address|range", Type: ArgWord}},
+		Run: func(sender CommandSender, args Args) error {
+			removed, err := bans.IPs.Remove(args.String(0))
+			if err != nil {
+				log.Print("While saving the IP ban list: ", err)
+				return errors.New("The ban list could not be saved.")
+			}
+			if !removed {
+				return errors.New("That address is not banned.")
+			}
+			sendChat(sender, ChatInfo+"Pardoned "+ChatPayload+args.String(0)+ChatInfo+".")
+			return nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:        "banlist",
+		Description: "List banned players, or banned addresses with \"ips\".",
+		Permission:  "stuzzd.command.ban",
+		Args:        []Arg{{Name: "ips", Type: ArgWord, Optional: true}},
+		Run: func(sender CommandSender, args Args) error {
+			list, what := bans.Players, "players"
+			if args.Has(0) {
+				if args.String(0) != "ips" {
+					return UsageError("")
+				}
+				list, what = bans.IPs, "addresses"
+			}
+
+			entries := list.Entries()
+			names := make([]string, len(entries))
+			for i, e := range entries {
+				names[i] = e.Name
+			}
+			sendChat(sender, fmt.Sprintf("%sThere are %d banned %s: %s%s", ChatInfo, len(names), what, ChatPayload, strings.Join(names, ", ")))
+			return nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:        "whitelist",
+		Description: "Turn the whitelist on or off, or change who is on it.",
+		Permission:  "stuzzd.command.whitelist",
+		Args:        []Arg{{Name: "on|off|list|add|remove", Type: ArgWord}, {Name: "player", Type: ArgWord, Optional: true}},
+		Run: func(sender CommandSender, args Args) error {
+			switch args.String(0) {
+			case "on", "off":
+				if args.Has(1) {
+					return UsageError("")
+				}
+				if err := config.SetWhitelist(args.String(0) == "on"); err != nil {
+					log.Print("While saving the config: ", err)
+					return errors.New("The whitelist is now " + args.String(0) + ", but the config could not be saved.")
+				}
+				sendChat(sender, ChatInfo+"The whitelist is now "+ChatPayload+args.String(0)+ChatInfo+".")
+				return nil
+			case "list":
+				names := bans.Whitelist.Names()
+				sendChat(sender, fmt.Sprintf("%sThere are %d whitelisted players: %s%s", ChatInfo, len(names), ChatPayload, strings.Join(names, ", ")))
+				return nil
+			case "add", "remove":
+				if !args.Has(1) {
+					return UsageError("")
+				}
+				name := args.String(1)
+
+				var changed bool
+				var err error
+				if args.String(0) == "add" {
+					changed, err = bans.Whitelist.Add(name)
+				} else {
+					changed, err = bans.Whitelist.Remove(name)
+				}
+				if err != nil {
+					log.Print("While saving the whitelist: ", err)
+					return errors.New("The whitelist could not be saved.")
+				}
+				if !changed {
+					return errors.New("Nothing needed to change.")
+				}
+				sendChat(sender, ChatInfo+"Updated the whitelist.")
+				return nil
+			}
+			return UsageError("")
+		},
+	})
+}
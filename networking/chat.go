@@ -0,0 +1,251 @@
+package networking
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Nightgunner5/stuzzd/bans"
+	"github.com/Nightgunner5/stuzzd/config"
+	"github.com/Nightgunner5/stuzzd/protocol"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// The longest chat message the client will send.
+	maxChatLength = 100
+
+	// Each message adds chatSpamCost to a player's spam counter and each tick takes one away. A player whose counter
+	// goes over chatSpamLimit is kicked.
+	chatSpamCost  = 20
+	chatSpamLimit = 200
+
+	// A message that is the same as the player's last one within this many ticks is dropped.
+	repeatTicks = 10 * 20
+
+	// A player kicked for spam this many times within spamKickWindow is banned for spamBanLength.
+	spamKicksBeforeBan = 3
+	spamKickWindow     = time.Hour
+	spamBanLength      = 10 * time.Minute
+)
+
+// The characters the client's font can draw. § is not allowed because it would let players use formatting codes.
+const chatCharacters = " !\"#$%&'()*+,-./0123456789:;<=>?@ABCDEFGHIJKLMNOPQRSTUVWXYZ[\\]^_`abcdefghijklmnopqrstuvwxyz{|}~⌂ÇüéâäàåçêëèïîìÄÅÉæÆôöòûùÿÖÜø£Ø×ƒáíóúñÑªº¿®¬½¼¡«»"
+
+var errMuted = errors.New("You are muted.")
+
+// Handles a chat packet from a player: a command or a message to everyone.
+func handleChat(p *_player, message string) {
+	if len([]rune(message)) > maxChatLength {
+		p.SendPacketSync(protocol.Kick{Reason: "Chat message too long"})
+		return
+	}
+	for _, r := range message {
+		if !strings.ContainsRune(chatCharacters, r) {
+			p.SendPacketSync(protocol.Kick{Reason: "Illegal characters in chat"})
+			return
+		}
+	}
+
+	message = strings.TrimSpace(message)
+	if message == "" {
+		return
+	}
+
+	if p.throttleChat() {
+		kickForSpam(p)
+		return
+	}
+
+	if strings.HasPrefix(message, "/") {
+		handleCommand(p, message[1:])
+		return
+	}
+
+	if p.isMuted() {
+		sendChat(p, ChatError+errMuted.Error())
+		return
+	}
+	if p.isRepeat(message) {
+		sendChat(p, ChatError+"Please don't repeat yourself.")
+		return
+	}
+
+	message = filterChat(message)
+	log.Printf("<%s> %s", p.Username(), message)
+	broadcastChat(p, fmt.Sprintf("%s %s", bracketUsername(p), message))
+}
+
+// Counts a message against the player's spam limit. Returns true if they have gone over it.
+func (p *_player) throttleChat() bool {
+	p.chatLock.Lock()
+	defer p.chatLock.Unlock()
+
+	if elapsed := config.CurrentTick() - p.chatSpamTick; elapsed < p.chatSpam {
+		p.chatSpam -= elapsed
+	} else {
+		p.chatSpam = 0
+	}
+	p.chatSpamTick = config.CurrentTick()
+	p.chatSpam += chatSpamCost
+
+	return p.chatSpam > chatSpamLimit
+}
+
+// Returns true if the message is the same as the player's last one and was sent soon after it.
+func (p *_player) isRepeat(message string) bool {
+	p.chatLock.Lock()
+	defer p.chatLock.Unlock()
+
+	repeat := strings.EqualFold(message, p.lastChat) && config.CurrentTick()-p.lastChatTick < repeatTicks
+	p.lastChat, p.lastChatTick = message, config.CurrentTick()
+	return repeat
+}
+
+var (
+	spamKickLock sync.Mutex
+	spamKicks    = make(map[string][]time.Time) // Lowercase username to recent spam kicks
+)
+
+// Kicks the player for flooding the chat, or bans them for a while if they keep doing it.
+func kickForSpam(p *_player) {
+	name := strings.ToLower(p.Username())
+	now := time.Now()
+
+	spamKickLock.Lock()
+	var recent []time.Time
+	for _, t := range spamKicks[name] {
+		if now.Sub(t) < spamKickWindow {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+	spamKicks[name] = recent
+	spamKickLock.Unlock()
+
+	if len(recent) < spamKicksBeforeBan {
+		p.SendPacketSync(protocol.Kick{Reason: "Kicked for spamming"})
+		return
+	}
+
+	ban := &bans.Entry{Name: p.Username(), Source: "Server", Expires: now.Add(spamBanLength), Reason: "Flooding the chat."}
+	if err := bans.Players.Add(ban); err != nil {
+		log.Print("While saving the ban list: ", err)
+	}
+	p.SendPacketSync(protocol.Kick{Reason: "You are banned from this server! " + ban.String()})
+}
+
+// Replaces the words in config.Config.ChatFilter with asterisks.
+func filterChat(message string) string {
+	lower := strings.ToLower(message)
+	runes := []rune(message)
+	for _, word := range config.Config.ChatFilter {
+		word = strings.ToLower(word)
+		if word == "" {
+			continue
+		}
+		for start := 0; ; {
+			i := strings.Index(lower[start:], word)
+			if i < 0 {
+				break
+			}
+			// Convert byte offsets to rune offsets so non-ASCII messages are masked in the right place.
+			from := len([]rune(lower[:start+i]))
+			for j := from; j < from+len([]rune(word)) && j < len(runes); j++ {
+				runes[j] = '*'
+			}
+			start += i + len(word)
+		}
+	}
+	return string(runes)
+}
+
+func (p *_player) isMuted() bool {
+	p.chatLock.Lock()
+	defer p.chatLock.Unlock()
+
+	until := p.stored.StuzzDMutedUntil
+	if until > 0 && time.Now().Unix() >= until {
+		p.stored.StuzzDMutedUntil = 0
+		return false
+	}
+	return until != 0
+}
+
+// Mutes the player until the given time, or forever if it is zero.
+func (p *_player) mute(until time.Time) {
+	p.chatLock.Lock()
+	defer p.chatLock.Unlock()
+
+	if until.IsZero() {
+		p.stored.StuzzDMutedUntil = -1
+	} else {
+		p.stored.StuzzDMutedUntil = until.Unix()
+	}
+}
+
+// Returns false if the player wasn't muted.
+func (p *_player) unmute() bool {
+	muted := p.isMuted()
+
+	p.chatLock.Lock()
+	defer p.chatLock.Unlock()
+
+	p.stored.StuzzDMutedUntil = 0
+	return muted
+}
+
+// Returns errMuted if the sender is a muted player.
+func checkMuted(sender CommandSender) error {
+	if p, ok := sender.(*_player); ok && p.isMuted() {
+		return errMuted
+	}
+	return nil
+}
+
+func init() {
+	RegisterCommand(&Command{
+		Name:        "mute",
+		Description: "Stop a player from chatting, optionally for a time like 30m, 12h or 7d.",
+		Permission:  "stuzzd.command.mute",
+		Args:        []Arg{{Name: "player", Type: ArgPlayer}, {Name: "duration", Type: ArgWord, Optional: true}},
+		Run: func(sender CommandSender, args Args) error {
+			target := args.Player(0).(*_player)
+
+			var until time.Time
+			if args.Has(1) {
+				d, err := bans.ParseDuration(args.String(1))
+				if err != nil {
+					return UsageError(args.String(1) + " is not a duration.")
+				}
+				until = time.Now().Add(d)
+			}
+			target.mute(until)
+
+			if until.IsZero() {
+				sendChat(target, ChatInfo+"You have been muted.")
+			} else {
+				sendChat(target, ChatInfo+"You have been muted until "+until.Format("15:04 MST")+".")
+			}
+			sendChat(sender, ChatInfo+"Muted "+formatUsername(target)+".")
+			return nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:        "unmute",
+		Description: "Let a muted player chat again.",
+		Permission:  "stuzzd.command.mute",
+		Args:        []Arg{{Name: "player", Type: ArgPlayer}},
+		Run: func(sender CommandSender, args Args) error {
+			target := args.Player(0).(*_player)
+			if !target.unmute() {
+				return errors.New("That player isn't muted.")
+			}
+
+			sendChat(target, ChatInfo+"You can chat again.")
+			sendChat(sender, ChatInfo+"Unmuted "+formatUsername(target)+".")
+			return nil
+		},
+	})
+}
@@ -0,0 +1,196 @@
+package networking
+
+import (
+	"github.com/Nightgunner5/stuzzd/chunk"
+	"github.com/Nightgunner5/stuzzd/config"
+	"github.com/Nightgunner5/stuzzd/scheduler"
+	"github.com/Nightgunner5/stuzzd/storage"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Chunks are only unloaded once they are this many chunks outside the view distance, so walking back and forth
+// over a chunk border doesn't unload and resend a whole ring of chunks each time.
+const unloadMargin = 2
+
+type chunkOffset struct {
+	dx, dz int32
+}
+
+var (
+	spiralLock     sync.Mutex
+	spiralDistance int32
+	spiral         []chunkOffset
+)
+
+// Returns the offsets of every chunk within distance chunks of the center, ordered from the center outward.
+func spiralOrder(distance int32) []chunkOffset {
+	spiralLock.Lock()
+	defer spiralLock.Unlock()
+
+	if spiral != nil && spiralDistance == distance {
+		return spiral
+	}
+
+	offsets := make([]chunkOffset, 0, (2*distance+1)*(2*distance+1))
+	for dx := -distance; dx <= distance; dx++ {
+		for dz := -distance; dz <= distance; dz++ {
+			offsets = append(offsets, chunkOffset{dx, dz})
+		}
+	}
+	sort.Sort(byRing(offsets))
+
+	spiral, spiralDistance = offsets, distance
+	return spiral
+}
+
+// Which square ring around the center an offset is in.
+func (o chunkOffset) ring() int32 {
+	dx, dz := o.dx, o.dz
+	if dx < 0 {
+		dx = -dx
+	}
+	if dz < 0 {
+		dz = -dz
+	}
+	if dx > dz {
+		return dx
+	}
+	return dz
+}
+
+type byRing []chunkOffset
+
+func (s byRing) Len() int      { return len(s) }
+func (s byRing) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byRing) Less(i, j int) bool {
+	if ri, rj := s[i].ring(), s[j].ring(); ri != rj {
+		return ri < rj
+	}
+	return s[i].dx*s[i].dx+s[i].dz*s[i].dz < s[j].dx*s[j].dx+s[j].dz*s[j].dz
+}
+
+func viewDistance() int32 {
+	if config.Config.ViewDistance < 1 {
+		return 1
+	}
+	return config.Config.ViewDistance
+}
+
+func chunksPerTick() int {
+	if config.Config.ChunksPerTick < 1 {
+		return 1
+	}
+	return config.Config.ChunksPerTick
+}
+
+// Wakes up each player's chunk streamer. A streamer that is still busy with the last tick misses this one.
+func wakeChunkStreamers() {
+	for _, p := range registered.onlinePlayers() {
+		select {
+		case p.(*_player).streamWake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func init() {
+	scheduler.EveryTick("chunk streaming", wakeChunkStreamers)
+}
+
+// Sends the chunks around the player as they move, until the player disconnects.
+func (p *_player) streamChunks() {
+	defer p.releaseChunks()
+
+	for {
+		select {
+		case <-p.streamWake:
+		case <-p.quit:
+			return
+		}
+
+		x, _, z := p.Position()
+		centerX, centerZ := int32(math.Floor(x))>>4, int32(math.Floor(z))>>4
+		distance := viewDistance()
+
+		p.unloadChunksOutside(centerX, centerZ, distance+unloadMargin)
+
+		ready := true
+		budget := chunksPerTick()
+		for _, offset := range spiralOrder(distance) {
+			cx, cz := centerX+offset.dx, centerZ+offset.dz
+			if p.hasChunk(cx, cz) {
+				continue
+			}
+			if budget <= 0 {
+				if offset.ring() <= 1 {
+					ready = false
+				}
+				break
+			}
+			budget--
+
+			p.sendChunkIfNeeded(cx, cz)
+		}
+
+		// The client falls through the world if it spawns before the chunks around it arrive.
+		// Marking them spawned first means a respawn that unmarks them afterwards always gets a spawn packet
+		// after its Respawn packet.
+		if ready && atomic.CompareAndSwapInt32(&p.spawned, 0, 1) {
+			p.sendSpawnPacket()
+		}
+	}
+}
+
+// Loads a chunk and sends it to the player unless they already have it.
+func (p *_player) sendChunkIfNeeded(x, z int32) {
+	if p.hasChunk(x, z) {
+		return
+	}
+
+	c := storage.GetChunk(x, z)
+	id := uint64(uint32(x))<<32 | uint64(uint32(z))
+
+	p.chunkLock.Lock()
+	if _, ok := p.chunkSet[id]; ok {
+		// Someone else sent it while we were loading it.
+		p.chunkLock.Unlock()
+		storage.ReleaseChunk(x, z)
+		return
+	}
+	p.chunkSet[id] = c
+	p.chunkLock.Unlock()
+
+	sendChunk(p, x, z, c)
+}
+
+// Tells the client to forget the chunks that are more than distance chunks from the center.
+func (p *_player) unloadChunksOutside(centerX, centerZ, distance int32) {
+	var unloaded []*chunk.Chunk
+
+	p.chunkLock.Lock()
+	for id, c := range p.chunkSet {
+		if (chunkOffset{c.X - centerX, c.Z - centerZ}).ring() > distance {
+			unloaded = append(unloaded, c)
+			delete(p.chunkSet, id)
+		}
+	}
+	p.chunkLock.Unlock()
+
+	for _, c := range unloaded {
+		storage.ReleaseChunk(c.X, c.Z)
+		sendChunk(p, c.X, c.Z, nil)
+	}
+}
+
+func (p *_player) releaseChunks() {
+	p.chunkLock.Lock()
+	defer p.chunkLock.Unlock()
+
+	for id, c := range p.chunkSet {
+		storage.ReleaseChunk(c.X, c.Z)
+		delete(p.chunkSet, id)
+	}
+}
@@ -0,0 +1,104 @@
+package networking
+
+import (
+	"github.com/Nightgunner5/stuzzd/chunk"
+	"github.com/Nightgunner5/stuzzd/config"
+	"github.com/Nightgunner5/stuzzd/player"
+	"github.com/Nightgunner5/stuzzd/protocol"
+	"math"
+	"math/rand"
+)
+
+const (
+	// How hard a hit pushes its target, in blocks per tick.
+	knockbackStrength = 0.4
+	knockbackLift     = 0.4
+
+	exhaustionAttack = 0.3
+)
+
+// Entities that players can hit.
+type attackable interface {
+	// Hurts the entity and knocks it away from the attacker. Returns false if the hit did nothing, for example
+	// because the entity is invulnerable or was hit too recently, or if it hasn't been applied yet.
+	attacked(attacker *_player, damage int) bool
+}
+
+func (p *_player) useEntity(pkt protocol.UseEntity) {
+	if pkt.User != p.id || !pkt.LeftClick || !p.isSpawned() || p.isDead() {
+		return
+	}
+
+	// Players can only hit what their client can see.
+	var target attackable
+	switch ent := p.trackedEntity(pkt.Target).(type) {
+	case *_player:
+		if !config.Config.PvP {
+			return
+		}
+		x, y, z := p.Position()
+		tx, ty, tz := ent.Position()
+		if (x-tx)*(x-tx)+(y-ty)*(y-ty)+(z-tz)*(z-tz) > maxReach*maxReach {
+			return
+		}
+		target = ent
+	case chunk.Entity:
+		// The mob's ticker checks the reach, and ignores the hit if it isn't a mob.
+		target = mobTarget(pkt.Target)
+	default:
+		return
+	}
+
+	p.inventoryLock.Lock()
+	damage := player.AttackDamage(0)
+	if held := p.heldItem(); held != nil {
+		damage = player.AttackDamage(held.Type)
+	}
+	p.inventoryLock.Unlock()
+
+	if target.attacked(p, damage) {
+		p.exhaustFromAttack()
+	}
+}
+
+// Makes the player hungrier for landing a hit.
+func (p *_player) exhaustFromAttack() {
+	p.statsLock.Lock()
+	p.stored.FoodExhaustionLevel += exhaustionAttack
+	p.statsLock.Unlock()
+}
+
+func (p *_player) attacked(attacker *_player, damage int) bool {
+	x, _, z := attacker.Position()
+	return p.attackedBy(formatUsername(attacker), x, z, damage, damageAttack)
+}
+
+// Hurts the player and knocks them away from fromX, fromZ. The attacker's name goes in the death message.
+func (p *_player) attackedBy(attacker string, fromX, fromZ float64, damage int, cause damageCause) bool {
+	p.statsLock.Lock()
+	health := p.stored.Health
+	p.attacker = attacker
+	p.hurt(damage, cause)
+	hit := p.stored.Health < health
+	p.statsLock.Unlock()
+
+	if hit {
+		// The client moves its own player, so the knockback only needs to go to them.
+		x, _, z := p.Position()
+		vx, vy, vz := knockback(x, z, fromX, fromZ)
+		go p.SendPacketSync(protocol.EntityVelocity{ID: p.id, VX: vx, VY: vy, VZ: vz})
+	}
+	return hit
+}
+
+// Returns the velocity that pushes something at x, z directly away from fromX, fromZ.
+func knockback(x, z, fromX, fromZ float64) (vx, vy, vz float64) {
+	dx, dz := x-fromX, z-fromZ
+	distance := math.Sqrt(dx*dx + dz*dz)
+	if distance < 0.0001 {
+		// Standing in the same place. Pick a direction.
+		angle := rand.Float64() * 2 * math.Pi
+		dx, dz, distance = math.Cos(angle), math.Sin(angle), 1
+	}
+	return dx / distance * knockbackStrength, knockbackLift, dz / distance * knockbackStrength
+}
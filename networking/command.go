@@ -0,0 +1,340 @@
+package networking
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type ArgType uint8
+
+const (
+	ArgPlayer      ArgType = iota // An online player, by name. Parsed as a Player.
+	ArgCoordinates                // Three numbers, x y z. A ~ prefix makes a number relative to the sender. Parsed as Coordinates.
+	ArgInteger                    // Parsed as an int.
+	ArgWord                       // A single word. Parsed as a string.
+	ArgRest                       // Everything left on the line. Parsed as a string. Must be the last argument.
+)
+
+type Arg struct {
+	Name     string
+	Type     ArgType
+	Optional bool // Every argument after an optional argument must also be optional.
+}
+
+type Coordinates struct {
+	X, Y, Z float64
+}
+
+// The parsed arguments to a command, in the order they were declared. Optional arguments that were not given are nil.
+type Args []interface{}
+
+func (a Args) Has(i int) bool                { return a[i] != nil }
+func (a Args) Player(i int) Player           { return a[i].(Player) }
+func (a Args) Coordinates(i int) Coordinates { return a[i].(Coordinates) }
+func (a Args) Int(i int) int                 { return a[i].(int) }
+func (a Args) String(i int) string           { return a[i].(string) }
+
+type Command struct {
+	Name        string
+	Aliases     []string
+	Description string
+
+	// The permission node needed to use the command, or "" if anyone can use it.
+	Permission string
+
+	Args []Arg
+
+	// Don't log each time the command is used.
+	Quiet bool
+
+	// Returning a UsageError shows the sender how to use the command. Any other error is shown to the sender as is.
+	Run func(sender CommandSender, args Args) error
+}
+
+// Returns how the command is used, like "/kick <player> [reason]".
+func (c *Command) Usage() string {
+	usage := "/" + c.Name
+	for _, arg := range c.Args {
+		name := arg.Name
+		if arg.Type == ArgRest {
+			name += "..."
+		}
+		if arg.Optional {
+			usage += " [" + name + "]"
+		} else {
+			usage += " <" + name + ">"
+		}
+	}
+	return usage
+}
+
+// Tells the sender they used a command wrong. The command's usage is shown after the message.
+type UsageError string
+
+func (err UsageError) Error() string {
+	return string(err)
+}
+
+// Returned by commands that need a player to run them.
+var ErrNotPlayer = errors.New("Only players can use this command.")
+
+var (
+	commandLock sync.RWMutex
+	commands    = make(map[string]*Command)
+	commandList []*Command // Sorted by name
+)
+
+// Makes a command available. Registering two commands with the same name or alias panics.
+func RegisterCommand(cmd *Command) {
+	commandLock.Lock()
+	defer commandLock.Unlock()
+
+	for _, name := range append([]string{cmd.Name}, cmd.Aliases...) {
+		name = strings.ToLower(name)
+		if _, ok := commands[name]; ok {
+			panic("networking: command /" + name + " registered twice")
+		}
+		commands[name] = cmd
+	}
+
+	commandList = append(commandList, cmd)
+	sort.Sort(commandsByName(commandList))
+}
+
+type commandsByName []*Command
+
+func (s commandsByName) Len() int           { return len(s) }
+func (s commandsByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s commandsByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+
+func findCommand(name string) *Command {
+	commandLock.RLock()
+	defer commandLock.RUnlock()
+
+	return commands[strings.ToLower(name)]
+}
+
+// Returns the commands the sender is allowed to use, sorted by name.
+func availableCommands(sender CommandSender) []*Command {
+	commandLock.RLock()
+	defer commandLock.RUnlock()
+
+	available := make([]*Command, 0, len(commandList))
+	for _, cmd := range commandList {
+		if canUse(sender, cmd.Permission) {
+			available = append(available, cmd)
+		}
+	}
+	return available
+}
+
+func handleCommand(sender CommandSender, line string) {
+	words := strings.Fields(line)
+	if len(words) == 0 {
+		return
+	}
+
+	cmd := findCommand(words[0])
+	if cmd == nil {
+		sendChat(sender, ChatError+"Unknown command. Try /help.")
+		return
+	}
+
+	if !cmd.Quiet {
+		log.Printf("Command from %s: /%s", sender.Username(), line)
+	}
+
+	if !checkPermission(sender, cmd.Permission) {
+		return
+	}
+
+	args, err := parseArgs(sender, cmd.Args, words[1:])
+	if err == nil {
+		err = runCommand(sender, cmd, args)
+	}
+
+	switch e := err.(type) {
+	case nil:
+	case UsageError:
+		if e != "" {
+			sendChat(sender, ChatError+string(e))
+		}
+		sendChat(sender, ChatInfo+"Usage: "+ChatPayload+cmd.Usage())
+	default:
+		sendChat(sender, ChatError+err.Error())
+	}
+}
+
+func runCommand(sender CommandSender, cmd *Command, args Args) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Command /%s from %s panicked: %v", cmd.Name, sender.Username(), r)
+			err = errors.New("Something went wrong while running that command.")
+		}
+	}()
+
+	return cmd.Run(sender, args)
+}
+
+func parseArgs(sender CommandSender, declared []Arg, words []string) (Args, error) {
+	args := make(Args, len(declared))
+	for i, arg := range declared {
+		if len(words) == 0 {
+			if arg.Optional {
+				continue
+			}
+			return nil, UsageError("")
+		}
+
+		switch arg.Type {
+		case ArgPlayer:
+			target := FindPlayer(words[0])
+			if target == nil {
+				return nil, fmt.Errorf("Could not find player %s.", words[0])
+			}
+			args[i] = target
+			words = words[1:]
+
+		case ArgCoordinates:
+			if len(words) < 3 {
+				return nil, UsageError("")
+			}
+			coords, err := parseCoordinates(sender, words[:3])
+			if err != nil {
+				return nil, err
+			}
+			args[i] = coords
+			words = words[3:]
+
+		case ArgInteger:
+			n, err := strconv.Atoi(words[0])
+			if err != nil {
+				return nil, UsageError(fmt.Sprintf("%s is not a number.", words[0]))
+			}
+			args[i] = n
+			words = words[1:]
+
+		case ArgWord:
+			args[i] = words[0]
+			words = words[1:]
+
+		case ArgRest:
+			args[i] = strings.Join(words, " ")
+			words = nil
+		}
+	}
+
+	if len(words) != 0 {
+		return nil, UsageError("Too many arguments.")
+	}
+	return args, nil
+}
+
+func parseCoordinates(sender CommandSender, words []string) (Coordinates, error) {
+	var base [3]float64
+	if p, ok := sender.(Player); ok {
+		base[0], base[1], base[2] = p.Position()
+	}
+
+	var coords [3]float64
+	for i, word := range words {
+		relative := strings.HasPrefix(word, "~")
+		if relative {
+			if _, ok := sender.(Player); !ok {
+				return Coordinates{}, UsageError("Only players can use relative coordinates.")
+			}
+			word = word[1:]
+			if word == "" {
+				word = "0"
+			}
+		}
+
+		n, err := strconv.ParseFloat(word, 64)
+		if err != nil {
+			return Coordinates{}, UsageError(fmt.Sprintf("%s is not a coordinate.", words[i]))
+		}
+		if relative {
+			n += base[i]
+		}
+		coords[i] = n
+	}
+
+	return Coordinates{coords[0], coords[1], coords[2]}, nil
+}
+
+// Returns true if the sender can use things that need the given permission node.
+func canUse(sender CommandSender, permission string) bool {
+	return permission == "" || HasPermission(sender, permission)
+}
+
+// Like canUse, but tells the sender when they are not allowed.
+func checkPermission(sender CommandSender, permission string) bool {
+	if canUse(sender, permission) {
+		return true
+	}
+	sendChat(sender, ChatNotAllowed)
+	return false
+}
+
+const helpPageSize = 8
+
+func init() {
+	RegisterCommand(&Command{
+		Name:        "help",
+		Aliases:     []string{"?"},
+		Description: "List commands, or explain how to use one.",
+		Args:        []Arg{{Name: "page or command", Type: ArgWord, Optional: true}},
+		Quiet:       true,
+		Run:         commandHelp,
+	})
+}
+
+func commandHelp(sender CommandSender, args Args) error {
+	page := 1
+	if args.Has(0) {
+		if n, err := strconv.Atoi(args.String(0)); err == nil {
+			page = n
+		} else {
+			cmd := findCommand(strings.TrimPrefix(args.String(0), "/"))
+			if cmd == nil || !canUse(sender, cmd.Permission) {
+				return fmt.Errorf("There is no command named %s.", args.String(0))
+			}
+
+			sendChat(sender, ChatInfo+"=== "+ChatPayload+"/"+cmd.Name+ChatInfo+" ===")
+			sendChat(sender, ChatPayload+cmd.Description)
+			sendChat(sender, ChatInfo+"Usage: "+ChatPayload+cmd.Usage())
+			if len(cmd.Aliases) != 0 {
+				sendChat(sender, ChatInfo+"Aliases: "+ChatPayload+"/"+strings.Join(cmd.Aliases, ", /"))
+			}
+			return nil
+		}
+	}
+
+	available := availableCommands(sender)
+	pages := (len(available) + helpPageSize - 1) / helpPageSize
+	if page < 1 || page > pages {
+		return fmt.Errorf("There are only %d pages of help.", pages)
+	}
+
+	sendChat(sender, fmt.Sprintf("%s=== %sHelp%s (page %d of %d) ===", ChatInfo, ChatPayload, ChatInfo, page, pages))
+	end := page * helpPageSize
+	if end > len(available) {
+		end = len(available)
+	}
+	for _, cmd := range available[(page-1)*helpPageSize : end] {
+		name := ChatName
+		if cmd.Permission != "" {
+			name = ChatNameOp
+		}
+		sendChat(sender, name+"/"+cmd.Name+ChatInfo+" - "+ChatPayload+cmd.Description)
+	}
+	if page < pages {
+		sendChat(sender, fmt.Sprintf("%sType /help %d for more.", ChatInfo, page+1))
+	}
+	return nil
+}
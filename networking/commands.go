@@ -1,322 +1,238 @@
 package networking
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
 	"github.com/Nightgunner5/stuzzd/config"
+	"github.com/Nightgunner5/stuzzd/permissions"
 	"github.com/Nightgunner5/stuzzd/protocol"
-	"io"
+	"github.com/Nightgunner5/stuzzd/scheduler"
 	"log"
-	"os"
 	"strings"
-	"sync"
+	"sync/atomic"
 )
 
 const (
-	ChatInfo         = "§7"
-	ChatError        = "§c"
-	ChatPayload      = "§r"
-	ChatName         = "§6§l"
-	ChatNameOp       = "§4§l"
-	ChatNameNetAdmin = "§3§l"
-	ChatNotAllowed   = ChatError + "You do not have the required permission to use that command."
+	ChatInfo       = "§7"
+	ChatError      = "§c"
+	ChatPayload    = "§r"
+	ChatName       = "§6§l"
+	ChatNameOp     = "§4§l"
+	ChatNotAllowed = ChatError + "You do not have the required permission to use that command."
 )
 
-var CommandHelp = map[string]struct {
-	Description string
-	OpOnly      bool
-}{
-	"help":  {Description: "This command.", OpOnly: false},
-	"me":    {Description: "Describe an action, like \"/me eats a cupcake.\"", OpOnly: false},
-	"who":   {Description: "List the players currently online.", OpOnly: false},
-	"op":    {Description: "Give a player operator status.", OpOnly: true},
-	"deop":  {Description: "Revoke a player's operator status.", OpOnly: true},
-	"kick":  {Description: "Kick a player from the server with an optional message.", OpOnly: true},
-	"tpt":   {Description: "Teleport yourself to a player.", OpOnly: true},
-	"gm":    {Description: "Set a player's game mode to creative (c) or survival (s).", OpOnly: true},
-	"day":   {Description: "Advance to the next day.", OpOnly: true},
-	"night": {Description: "Advance to the next night.", OpOnly: true},
+var difficultyNames = [...]string{
+	protocol.Peaceful: "peaceful",
+	protocol.Easy:     "easy",
+	protocol.Normal:   "normal",
+	protocol.Hard:     "hard",
 }
 
-func handleCommand(player Player, command string) {
-	defer func() { recover() }()
-	words := strings.Split(command, " ")
-	switch words[0] {
-	case "me", "pl", "players", "list", "who", "help":
-		// Don't spam the log.
-	default:
-		log.Printf("Command from %s: /%s", player.Username(), command)
-	}
-	switch words[0] {
-	case "me":
-		if len(words) < 2 {
-			return
-		}
-		message := strings.Join(words[1:], " ")
-		log.Printf("* %s %s", player.Username(), message)
-		SendToAll(protocol.Chat{Message: fmt.Sprintf("%s %s", starUsername(player), message)})
-	case "pl", "players", "list", "who":
-		message := make([]string, 0, len(players))
-		for _, p := range players {
-			if p.Authenticated() {
+func init() {
+	RegisterCommand(&Command{
+		Name:        "me",
+		Description: "Describe an action, like \"/me eats a cupcake.\"",
+		Args:        []Arg{{Name: "action", Type: ArgRest}},
+		Quiet:       true,
+		Run: func(sender CommandSender, args Args) error {
+			if err := checkMuted(sender); err != nil {
+				return err
+			}
+
+			action := filterChat(args.String(0))
+			log.Printf("* %s %s", sender.Username(), action)
+			broadcastChat(sender, fmt.Sprintf("%s %s", starUsername(sender), action))
+			return nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:        "who",
+		Aliases:     []string{"pl", "players", "list"},
+		Description: "List the players currently online.",
+		Quiet:       true,
+		Run: func(sender CommandSender, args Args) error {
+			online := registered.onlinePlayers()
+			message := make([]string, 0, len(online))
+			for _, p := range online {
 				message = append(message, formatUsername(p))
 			}
-		}
-		sendChat(player, ChatInfo+"Currently online: "+strings.Join(message, ", "))
-	case "help":
-		player.SendPacketSync(protocol.Chat{Message: ChatInfo + "=== " + ChatPayload + "Help" + ChatInfo + " ==="})
-		for command, info := range CommandHelp {
-			if info.OpOnly {
-				if !IsOp(player) && !isNetworkAdmin(player) {
-					continue
-				}
-				sendChat(player, ChatNameOp+command+ChatInfo+" - "+ChatPayload+info.Description)
-			} else {
-				sendChat(player, ChatName+command+ChatInfo+" - "+ChatPayload+info.Description)
+			sendChat(sender, ChatInfo+"Currently online: "+strings.Join(message, ", "))
+			return nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:        "op",
+		Description: "Give a player operator status.",
+		Permission:  "stuzzd.command.op",
+		Args:        []Arg{{Name: "player", Type: ArgWord}},
+		Run: func(sender CommandSender, args Args) error {
+			added, err := permissions.AddToGroup(args.String(0), "op")
+			if err == permissions.ErrNoSuchGroup {
+				return UsageError("There is no op group. Create it with /perm group op create.")
 			}
-		}
-	case "op":
-		if !checkOp(player) {
-			return
-		}
-
-		var target Player
-		for _, p := range players {
-			if p.Authenticated() && p.Username() == words[1] {
-				target = p
-				break
+			if err != nil {
+				return err
 			}
-		}
-		if target == nil {
-			sendChat(player, ChatError+"Could not find target.")
-			return
-		}
-
-		if GrantOp(target) {
-			SendToAll(protocol.Chat{Message: fmt.Sprintf("%s has been given Operator privileges by %s.", formatUsername(target), formatUsername(player))})
-		} else {
-			sendChat(player, ChatError+"Target already has Op!")
-		}
-	case "deop", "unop":
-		if !checkOp(player) {
-			return
-		}
-
-		var target Player
-		for _, p := range players {
-			if p.Authenticated() && p.Username() == words[1] {
-				target = p
-				break
+			if !added {
+				return errors.New("Target already has Op!")
 			}
-		}
-		if target == nil {
-			sendChat(player, ChatError+"Could not find target.")
-			return
-		}
-
-		if RevokeOp(target) {
-			SendToAll(protocol.Chat{Message: fmt.Sprintf("%s has had their Operator privileges revoked by %s.", formatUsername(target), formatUsername(player))})
-		} else {
-			sendChat(player, ChatError+"Target doesn't have Op!")
-		}
-	case "kick":
-		if !checkOp(player) {
-			return
-		}
-
-		var target Player
-		for _, p := range players {
-			if p.Authenticated() && p.Username() == words[1] {
-				target = p
-				break
+			SendToAll(protocol.Chat{Message: fmt.Sprintf("%s has been given Operator privileges by %s.", formatUsernameOffline(args.String(0)), formatUsername(sender))})
+			return nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:        "deop",
+		Aliases:     []string{"unop"},
+		Description: "Revoke a player's operator status.",
+		Permission:  "stuzzd.command.deop",
+		Args:        []Arg{{Name: "player", Type: ArgWord}},
+		Run: func(sender CommandSender, args Args) error {
+			removed, err := permissions.RemoveFromGroup(args.String(0), "op")
+			if err != nil {
+				return err
 			}
-		}
-		if target == nil {
-			sendChat(player, ChatError+"Could not find target.")
-			return
-		}
-
-		message := "No reason given"
-		if len(words) > 2 {
-			message = "\"" + strings.Join(words[2:], " ") + "\""
-		}
-
-		go target.SendPacketSync(protocol.Kick{Reason: "Kicked by admin: " + message})
-
-	case "tpt":
-		if !checkOp(player) {
-			return
-		}
-
-		var target Player
-		for _, p := range players {
-			if p.Authenticated() && p.Username() == words[1] {
-				target = p
-				break
+			if !removed {
+				return errors.New("Target doesn't have Op!")
 			}
-		}
-		if target == nil {
-			sendChat(player, ChatError+"Could not find target.")
-			return
-		}
-
-		player.SetPosition(target.Position())
-		player.ForcePosition()
-
-	case "gm", "gamemode":
-		if !checkOp(player) {
-			return
-		}
-
-		var target Player
-		for _, p := range players {
-			if p.Authenticated() && p.Username() == words[1] {
-				target = p
-				break
+			SendToAll(protocol.Chat{Message: fmt.Sprintf("%s has had their Operator privileges revoked by %s.", formatUsernameOffline(args.String(0)), formatUsername(sender))})
+			return nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:        "kick",
+		Description: "Kick a player from the server with an optional message.",
+		Permission:  "stuzzd.command.kick",
+		Args:        []Arg{{Name: "player", Type: ArgPlayer}, {Name: "reason", Type: ArgRest, Optional: true}},
+		Run: func(sender CommandSender, args Args) error {
+			message := "No reason given"
+			if args.Has(1) {
+				message = "\"" + args.String(1) + "\""
 			}
-		}
-		if target == nil {
-			sendChat(player, ChatError+"Could not find target.")
-			return
-		}
-
-		switch words[2] {
-		case "creative", "c", "1":
-			target.SetGameMode(protocol.Creative)
-		case "survival", "s", "0":
-			target.SetGameMode(protocol.Survival)
-		default:
-			sendChat(player, ChatError+"Unknown game mode.")
-			return
-		}
-
-		sendChat(player, ChatInfo+"You have changed "+formatUsername(target)+"'s game mode.")
-		sendChat(target, formatUsername(player)+" has changed your game mode.")
 
-	case "day":
-		if !checkOp(player) {
-			return
-		}
-
-		config.Tick += 24000 - (config.Tick % 24000)
-
-		SendToAll(protocol.TimeUpdate{Time: config.Tick})
-
-	case "night":
-		if !checkOp(player) {
-			return
-		}
+			go args.Player(0).SendPacketSync(protocol.Kick{Reason: "Kicked by admin: " + message})
+			return nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:        "gm",
+		Aliases:     []string{"gamemode"},
+		Description: "Set a player's game mode to creative (c), survival (s) or adventure (a).",
+		Permission:  "stuzzd.command.gamemode",
+		Args:        []Arg{{Name: "player", Type: ArgPlayer}, {Name: "mode", Type: ArgWord}},
+		Run: func(sender CommandSender, args Args) error {
+			target := args.Player(0)
+			switch args.String(1) {
+			case "creative", "c", "1":
+				target.SetGameMode(protocol.Creative)
+			case "survival", "s", "0":
+				target.SetGameMode(protocol.Survival)
+			case "adventure", "a", "2":
+				target.SetGameMode(protocol.Adventure)
+			default:
+				return UsageError("Unknown game mode.")
+			}
 
-		if config.Tick%24000 < 18000 {
-			config.Tick += 18000 - (config.Tick % 24000)
-		} else {
-			config.Tick += 24000 + 18000 - (config.Tick % 24000)
-		}
+			sendChat(sender, ChatInfo+"You have changed "+formatUsername(target)+"'s game mode.")
+			sendChat(target, formatUsername(sender)+" has changed your game mode.")
+			return nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:        "difficulty",
+		Description: "Set the difficulty to peaceful (p), easy (e), normal (n) or hard (h).",
+		Permission:  "stuzzd.command.difficulty",
+		Args:        []Arg{{Name: "difficulty", Type: ArgWord}},
+		Run: func(sender CommandSender, args Args) error {
+			var difficulty protocol.Difficulty
+			switch args.String(0) {
+			case "peaceful", "p", "0":
+				difficulty = protocol.Peaceful
+			case "easy", "e", "1":
+				difficulty = protocol.Easy
+			case "normal", "n", "2":
+				difficulty = protocol.Normal
+			case "hard", "h", "3":
+				difficulty = protocol.Hard
+			default:
+				return UsageError("Unknown difficulty.")
+			}
 
-		SendToAll(protocol.TimeUpdate{Time: config.Tick})
+			saveErr := config.SetDifficulty(uint8(difficulty))
+			atomic.StoreInt32(&difficultyChanged, 1)
 
-	default:
-		sendChat(player, ChatError+"Unknown command.")
-	}
-}
-
-func checkOp(player Player) bool {
-	if !IsOp(player) {
-		if isNetworkAdmin(player) {
-			sendChat(player, ChatInfo+"Using network admin override...")
-		} else {
-			sendChat(player, ChatNotAllowed)
-			return false
-		}
-	}
-	return true
+			log.Printf("%s set the difficulty to %s.", sender.Username(), difficultyNames[difficulty])
+			SendToAll(protocol.Chat{Message: fmt.Sprintf("%s has set the difficulty to %s.", formatUsername(sender), difficultyNames[difficulty])})
+			if saveErr != nil {
+				log.Print("While saving the config: ", saveErr)
+				return errors.New("The difficulty is now " + difficultyNames[difficulty] + ", but the config could not be saved.")
+			}
+			return nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:        "say",
+		Description: "Broadcast a message to everyone on the server.",
+		Permission:  "stuzzd.command.say",
+		Args:        []Arg{{Name: "message", Type: ArgRest}},
+		Run: func(sender CommandSender, args Args) error {
+			log.Printf("[%s] %s", sender.Username(), args.String(0))
+			SendToAll(protocol.Chat{Message: fmt.Sprintf("§d[%s] %s", sender.Username(), args.String(0))})
+			return nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:        "stop",
+		Description: "Save the world and shut down the server.",
+		Permission:  "stuzzd.command.stop",
+		Run: func(sender CommandSender, args Args) error {
+			SendToAll(protocol.Chat{Message: fmt.Sprintf("%s is stopping the server.", formatUsername(sender))})
+			scheduler.Stop()
+			return nil
+		},
+	})
 }
 
-func sendChat(player Player, message string) {
+func sendChat(player CommandSender, message string) {
 	player.SendPacketSync(protocol.Chat{Message: message})
 }
 
-var ops = make(map[string]bool)
-
-func init() {
-	f, err := os.Open("ops.txt")
-	if err != nil {
-		log.Print("While trying to load ops.txt: ", err)
-		return
-	}
-	defer f.Close()
-
-	r := bufio.NewReader(f)
-	for {
-		op, err := r.ReadString('\n')
-		op = strings.TrimSpace(op)
-		if op != "" {
-			ops[op] = true
-		}
-		if err == io.EOF {
-			return
-		}
-		if err != nil {
-			log.Print("While trying to load ops.txt: ", err)
-			return
-		}
+// Returns true if the sender has the permission node. The console has every permission.
+func HasPermission(sender CommandSender, node string) bool {
+	if sender == Console {
+		return true
 	}
+	return permissions.Has(sender.Username(), node)
 }
 
-func IsOp(player Player) bool {
-	return ops[player.Username()]
-}
-
-var opLock sync.Mutex
-
-func GrantOp(player Player) bool {
-	opLock.Lock()
-	defer opLock.Unlock()
-
-	if ops[player.Username()] {
-		return false
+func formatUsername(player CommandSender) string {
+	if player == Console {
+		return ChatNameOp + player.Username() + ChatInfo
 	}
-
-	ops[player.Username()] = true
-	saveOps()
-	return true
-}
-
-func RevokeOp(player Player) bool {
-	opLock.Lock()
-	defer opLock.Unlock()
-
-	if !ops[player.Username()] {
-		return false
+	prefix, color := permissions.Format(player.Username())
+	if color == "" {
+		color = ChatName
 	}
-
-	delete(ops, player.Username())
-	saveOps()
-	return true
+	return ChatInfo + prefix + color + player.Username() + ChatInfo
 }
 
-func saveOps() {
-	f, _ := os.Create("ops.txt")
-	defer f.Close()
-	for op, _ := range ops {
-		io.WriteString(f, op+"\n")
+// Like formatUsername, but for players who might not be online.
+func formatUsernameOffline(username string) string {
+	if p := FindPlayer(username); p != nil {
+		return formatUsername(p)
 	}
+	return formatUsername(offlineSender(username))
 }
 
-func formatUsername(player Player) string {
-	if isNetworkAdmin(player) {
-		return ChatNameNetAdmin + player.Username() + ChatInfo
-	}
-	if IsOp(player) {
-		return ChatNameOp + player.Username() + ChatInfo
-	}
-	return ChatName + player.Username() + ChatInfo
-}
+// A player who isn't online. Anything sent to them is dropped.
+type offlineSender string
+
+func (s offlineSender) Username() string               { return string(s) }
+func (s offlineSender) SendPacketSync(protocol.Packet) {}
 
-func bracketUsername(player Player) string {
+func bracketUsername(player CommandSender) string {
 	return ChatInfo + "<" + formatUsername(player) + ">" + ChatPayload
 }
 
-func starUsername(player Player) string {
+func starUsername(player CommandSender) string {
 	return ChatInfo + "* " + formatUsername(player) + ChatPayload
 }
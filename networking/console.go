@@ -0,0 +1,61 @@
+package networking
+
+import (
+	"bufio"
+	"github.com/Nightgunner5/stuzzd/protocol"
+	"io"
+	"log"
+	"strings"
+)
+
+// Anything that can run commands: a player or the server console.
+type CommandSender interface {
+	Username() string
+	SendPacketSync(protocol.Packet)
+}
+
+// The server console. It can use every command and its chat goes to the log.
+type consoleSender struct{}
+
+var Console CommandSender = consoleSender{}
+
+func (consoleSender) Username() string {
+	return "CONSOLE"
+}
+
+func (consoleSender) SendPacketSync(packet protocol.Packet) {
+	if chat, ok := packet.(protocol.Chat); ok {
+		log.Print(stripColors(chat.Message))
+	}
+}
+
+// Removes § formatting codes from a chat message.
+func stripColors(message string) string {
+	var stripped []rune
+	skip := false
+	for _, r := range message {
+		switch {
+		case skip:
+			skip = false
+		case r == '§':
+			skip = true
+		default:
+			stripped = append(stripped, r)
+		}
+	}
+	return string(stripped)
+}
+
+// Runs each line read from in as a command from the console until in runs out.
+func ReadConsole(in io.Reader) {
+	r := bufio.NewScanner(in)
+	for r.Scan() {
+		command := strings.TrimPrefix(strings.TrimSpace(r.Text()), "/")
+		if command != "" {
+			handleCommand(Console, command)
+		}
+	}
+	if err := r.Err(); err != nil {
+		log.Print("While reading the console: ", err)
+	}
+}
@@ -0,0 +1,136 @@
+package networking
+
+import (
+	"fmt"
+	"github.com/Nightgunner5/stuzzd/config"
+	"github.com/Nightgunner5/stuzzd/protocol"
+	"github.com/Nightgunner5/stuzzd/scheduler"
+	"github.com/Nightgunner5/stuzzd/storage"
+	"log"
+	"math/rand"
+	"sync/atomic"
+)
+
+// Finishes the sentence "<player> ..." for each way of dying.
+var deathMessages = map[damageCause]string{
+	damageFall:        "hit the ground too hard",
+	damageDrowning:    "drowned",
+	damageSuffocation: "suffocated in a wall",
+	damageFire:        "went up in flames",
+	damageBurning:     "burned to death",
+	damageLava:        "tried to swim in lava",
+	damageVoid:        "fell out of the world",
+	damageStarvation:  "starved to death",
+	damageAttack:      "was slain by",
+	damageExplosion:   "was blown up by",
+}
+
+// Drops everything the player was carrying and tells everyone how they died. The client shows the death
+// screen by itself when it is sent zero health. The attacker is only used if the player was killed in combat.
+func (p *_player) die(cause damageCause, attacker string) {
+	p.inventoryLock.Lock()
+	p.returnCursor()
+	x, y, z := p.Position()
+	for _, item := range p.stored.Inventory {
+		item := item
+		dropStack(x+rand.Float64()-0.5, y+1, z+rand.Float64()-0.5, &item, 40)
+	}
+	p.stored.Inventory = nil
+	p.sendInventory()
+	p.inventoryLock.Unlock()
+
+	message := deathMessages[cause]
+	if cause == damageAttack || cause == damageExplosion {
+		message += " " + attacker
+	}
+	log.Print(p.Username(), " died: ", stripColors(message))
+	SendToAll(protocol.Chat{Message: fmt.Sprintf("%s %s.", formatUsername(p), message)})
+}
+
+func (p *_player) isDead() bool {
+	p.statsLock.Lock()
+	defer p.statsLock.Unlock()
+
+	return p.stored.Health == 0
+}
+
+// Where the player comes back after dying: their own spawn point if they have one, or the world spawn.
+func (p *_player) respawnPoint() (x, y, z float64) {
+	if p.stored.SpawnX != 0 || p.stored.SpawnY != 0 || p.stored.SpawnZ != 0 {
+		return float64(p.stored.SpawnX) + 0.5, float64(p.stored.SpawnY), float64(p.stored.SpawnZ) + 0.5
+	}
+	return spawnPoint()
+}
+
+// Brings a dead player back at their respawn point. The chunk streamer sends whatever chunks around the new
+// position the client doesn't have yet and then puts the player there.
+func (p *_player) respawn() {
+	p.statsLock.Lock()
+	if p.stored.Health != 0 {
+		p.statsLock.Unlock()
+		return
+	}
+	p.stored.ResetStats()
+	p.SetPosition(p.respawnPoint())
+	p.statsLock.Unlock()
+
+	p.sendRespawn()
+	// Only after the Respawn packet is queued, so the streamer's spawn packet can't go out ahead of it.
+	p.setSpawned(false)
+	log.Print(p.Username(), " respawned.")
+}
+
+// Sends the Respawn packet, which is also the only way to tell a client the difficulty has changed. In the same
+// dimension the client keeps its chunks and the other entities, but it replaces the player's own entity, so
+// everything the new entity needs is sent again. It all goes through the packet queue, behind anything already
+// queued and ahead of the spawn packet that puts the new entity in place.
+func (p *_player) sendRespawn() {
+	p.queuePacket(protocol.Respawn{
+		Dimension:   protocol.Dimension(config.Config.Dimension),
+		Difficulty:  protocol.Difficulty(config.CurrentDifficulty()),
+		ServerMode:  p.gameMode,
+		WorldHeight: 256,
+		LevelType:   config.Config.LevelType,
+	})
+	p.queuePacket(&p.stored.Abilities)
+	p.queuePacket(timeUpdate())
+	if storage.GetWeather().Raining {
+		p.queuePacket(rainPacket(true))
+	}
+
+	p.statsLock.Lock()
+	p.sentHealth = nil
+	p.statsLock.Unlock()
+
+	// The new entity starts out holding the first hotbar slot.
+	p.setHeldSlot(0)
+	p.inventoryLock.Lock()
+	p.sendInventory()
+	p.inventoryLock.Unlock()
+}
+
+// Set by /difficulty so the next tick tells every living player about the new difficulty. Accessed atomically.
+var difficultyChanged int32
+
+// Sends the Respawn packets for a difficulty change. Running on the tick goroutine keeps them from being
+// interleaved with each other.
+func sendDifficulty() {
+	if !atomic.CompareAndSwapInt32(&difficultyChanged, 1, 0) {
+		return
+	}
+
+	for _, p := range registered.onlinePlayers() {
+		// Dead players are told when they respawn.
+		if p := p.(*_player); !p.isDead() {
+			p.sendRespawn()
+			// A player who hasn't spawned yet is put in place by the chunk streamer.
+			if p.isSpawned() {
+				p.sendSpawnPacket()
+			}
+		}
+	}
+}
+
+func init() {
+	scheduler.EveryTick("difficulty", sendDifficulty)
+}
@@ -1,19 +1,25 @@
 package networking
 
 import (
-	"bytes"
 	"fmt"
+	"github.com/Nightgunner5/stuzzd/auth"
 	"github.com/Nightgunner5/stuzzd/block"
 	"github.com/Nightgunner5/stuzzd/chunk"
 	"github.com/Nightgunner5/stuzzd/config"
 	"github.com/Nightgunner5/stuzzd/protocol"
+	"github.com/Nightgunner5/stuzzd/scheduler"
 	"github.com/Nightgunner5/stuzzd/storage"
 	"log"
-	"net/http"
 	"strings"
 	"time"
 )
 
+// Decides whether players are who they say they are. It is chosen by config.Config.AuthMode.
+var Authenticator = auth.FromConfig()
+
+// How long a player logging in waits for their old connection to be kicked and saved.
+const loginTakeoverTimeout = 10 * time.Second
+
 func dispatchPacket(p Player, packet protocol.Packet) {
 	switch pkt := packet.(type) {
 	case protocol.KeepAlive:
@@ -27,29 +33,51 @@ func dispatchPacket(p Player, packet protocol.Packet) {
 			p.SendPacketSync(protocol.Kick{Reason: fmt.Sprint("Your username doesn't match the one you told me earlier. (", pkt.Username, " != ", p.Username(), ")")})
 			return
 		}
-		req, _ := http.Get(fmt.Sprintf("http://session.minecraft.net/game/checkserver.jsp?user=%s&serverId=%x", p.Username(), p.getLoginToken()))
-		buf := make([]byte, 3)
-		req.Body.Read(buf)
-		if string(buf) == "YES" {
-			p.(*_player).authenticated = true
-			if OnlinePlayerCount >= config.Config.NumSlots {
+		// The handshake was checked too, but a ban or whitelist change may have been made since then.
+		if refuseLogin(p.(*_player)) {
+			return
+		}
+		if err := Authenticator.Verify(p.Username(), Authenticator.ServerID(p.getLoginToken())); err == nil {
+			if other := FindPlayer(p.Username()); other != nil {
+				// Both connections would share the same stored player, so the old one has to be saved and gone
+				// before this one loads it.
+				go other.SendPacketSync(protocol.Kick{Reason: "You logged in from another location."})
+				select {
+				case <-other.(*_player).done:
+				case <-time.After(loginTakeoverTimeout):
+					p.SendPacketSync(protocol.Kick{Reason: "You are already logged in."})
+					return
+				}
+			}
+			if !registered.claimSlot(p, config.Config.NumSlots) {
 				p.SendPacketSync(protocol.Kick{Reason: "Server is full!"})
 				return
 			}
-			OnlinePlayerCount++
+			p.(*_player).stored = storage.GetPlayer(p.Username())
+			gameMode := p.(*_player).joinGameMode()
 			p.SendPacketSync(protocol.LoginRequest{
 				EntityID:   p.ID(),
-				LevelType:  "default",
-				ServerMode: protocol.Survival,
-				Dimension:  protocol.Overworld,
-				Difficulty: protocol.Peaceful,
+				LevelType:  config.Config.LevelType,
+				ServerMode: gameMode,
+				Dimension:  protocol.Dimension(config.Config.Dimension),
+				Difficulty: protocol.Difficulty(config.CurrentDifficulty()),
 				MaxPlayers: uint8(config.Config.NumSlots), // If you have more than 255 slots, I applaud you.
 			})
-			p.(*_player).stored = storage.GetPlayer(p.Username())
-			if p.(*_player).stored.Abilities.InstaBuild {
-				p.SetGameMode(protocol.Creative)
-			} else {
-				p.SetGameMode(protocol.Survival)
+			p.(*_player).setAuthenticated()
+			p.(*_player).statsLock.Lock()
+			if p.(*_player).stored.Health == 0 {
+				// They died and logged out before respawning.
+				p.(*_player).stored.ResetStats()
+				p.(*_player).SetPosition(p.(*_player).respawnPoint())
+			}
+			p.(*_player).statsLock.Unlock()
+			p.SetGameMode(gameMode)
+			p.(*_player).inventoryLock.Lock()
+			p.(*_player).sendInventory()
+			p.(*_player).inventoryLock.Unlock()
+			p.SendPacketSync(timeUpdate())
+			if storage.GetWeather().Raining {
+				p.SendPacketSync(rainPacket(true))
 			}
 			p.sendWorldData()
 			log.Print(p.Username(), " connected.")
@@ -58,38 +86,42 @@ func dispatchPacket(p Player, packet protocol.Packet) {
 			} else {
 				SendToAll(protocol.Chat{Message: fmt.Sprintf("%s connected.", formatUsername(p))})
 			}
-			var otherPlayers bytes.Buffer
-			for _, player := range players {
-				if player.Authenticated() && player != p {
-					player.SpawnPacket(&otherPlayers)
-				}
-			}
-			p.SendPacketSync(protocol.BakedPacket(otherPlayers.Bytes()))
-			SendToAllExcept(p, EntitySpawnPacket(p))
 		} else {
+			log.Print("Failed to verify ", p.Username(), ": ", err)
 			p.SendPacketSync(protocol.Kick{Reason: "Failed to verify username!"})
 		}
 	case protocol.Chat:
-		if pkt.Message[0] == '/' {
-			handleCommand(p, string(pkt.Message[1:]))
-		} else {
-			log.Printf("<%s> %s", p.Username(), pkt.Message)
-			SendToAll(protocol.Chat{Message: fmt.Sprintf("%s %s", bracketUsername(p), pkt.Message)})
+		if p.Authenticated() {
+			handleChat(p.(*_player), pkt.Message)
 		}
 	case protocol.Handshake:
 		data := strings.Split(pkt.Data, ";")
 		p.setUsername(data[0])
-		p.SendPacketSync(protocol.Handshake{fmt.Sprintf("%016x", p.getLoginToken())})
+		if refuseLogin(p.(*_player)) {
+			return
+		}
+		p.SendPacketSync(protocol.Handshake{Authenticator.ServerID(p.getLoginToken())})
+	case protocol.UseEntity:
+		if p.Authenticated() {
+			p.(*_player).useEntity(pkt)
+		}
+	case protocol.Respawn:
+		if p.Authenticated() {
+			p.(*_player).respawn()
+		}
 	case protocol.Flying:
-		// TODO
+		if p.(*_player).isSpawned() {
+			x, y, z := p.Position()
+			p.(*_player).moved(x, y, z, pkt.Ground)
+		}
 	case protocol.PlayerPosition:
-		p.SendPosition(pkt.X, pkt.Y1, pkt.Z)
+		p.(*_player).move(pkt.X, pkt.Y1, pkt.Z, pkt.Ground)
 		// TODO: validation
 	case protocol.PlayerLook:
 		p.SendAngles(pkt.Yaw, pkt.Pitch)
 	case protocol.PlayerPositionLook:
 		p.SendAngles(pkt.Yaw, pkt.Pitch)
-		p.SendPosition(pkt.X, pkt.Y1, pkt.Z)
+		p.(*_player).move(pkt.X, pkt.Y1, pkt.Z, pkt.Ground)
 		// TODO: validation
 	case protocol.PlayerDigging:
 		switch pkt.Status {
@@ -101,7 +133,10 @@ func dispatchPacket(p Player, packet protocol.Packet) {
 			}
 		case 2:
 			blockType := GetBlockAt(pkt.X, int32(pkt.Y), pkt.Z)
-			if blockType != block.Bedrock {
+			if !p.(*_player).stored.Abilities.MayBuild {
+				// Adventure mode. Put the block back on the client.
+				p.SendPacketSync(protocol.BlockChange{X: pkt.X, Y: pkt.Y, Z: pkt.Z, Block: blockType, Data: GetBlockDataAt(pkt.X, int32(pkt.Y), pkt.Z)})
+			} else if blockType != block.Bedrock {
 				item := blockType.ItemDrop()
 
 				if item != 0 {
@@ -110,16 +145,41 @@ func dispatchPacket(p Player, packet protocol.Packet) {
 
 				PlayerSetBlockAt(pkt.X, int32(pkt.Y), pkt.Z, block.Air, 0)
 			}
+		case 5:
+			// Stopped using the held item before finishing.
+			p.(*_player).stopEating()
 		}
 		// TODO: validation
+	case protocol.PlayerBlockPlacement:
+		if p.Authenticated() {
+			placeBlock(p, pkt)
+		}
+	case protocol.HeldItemChange:
+		if p.Authenticated() {
+			p.(*_player).setHeldSlot(pkt.Slot)
+		}
+	case protocol.WindowClick:
+		if p.Authenticated() {
+			p.(*_player).clickWindow(pkt)
+		}
+	case protocol.CreativeInventoryAction:
+		if p.Authenticated() {
+			p.(*_player).creativeInventoryAction(pkt)
+		}
+	case protocol.CloseWindow:
+		if p.Authenticated() {
+			p.(*_player).closeWindow()
+		}
+	case protocol.Transaction:
+		// The client is acknowledging a rejected click. The inventory was already resent.
 	case protocol.Animation:
 		if pkt.EID == p.ID() && pkt.Animation == 1 {
-			SendToAllExcept(p, pkt)
+			sendToObservers(p.ID(), pkt)
 		}
 	case protocol.PlayerAbilities:
 		p.(*_player).stored.Abilities.Flying = p.(*_player).stored.Abilities.MayFly && pkt.Flying
 	case protocol.ServerListPing:
-		p.SendPacketSync(protocol.Kick{Reason: fmt.Sprintf("%s§%d§%d", config.Config.ServerDescription, OnlinePlayerCount, config.Config.NumSlots)})
+		p.SendPacketSync(protocol.Kick{Reason: fmt.Sprintf("%s§%d§%d", config.Config.ServerDescription, OnlinePlayerCount(), config.Config.NumSlots)})
 	case protocol.Kick:
 		log.Print(p.Username(), " disconnected.")
 		SendToAll(protocol.Chat{Message: fmt.Sprintf("%s disconnected.", formatUsername(p))})
@@ -129,16 +189,11 @@ func dispatchPacket(p Player, packet protocol.Packet) {
 }
 
 func init() {
-	go func() {
-		for {
-			time.Sleep(1 * time.Second)
-			for _, player := range players {
-				if player.Authenticated() {
-					SendToAll(protocol.PlayerListItem{Name: player.Username(), Online: true, Ping: 50})
-				}
-			}
+	scheduler.Every(20, "player list", func() {
+		for _, player := range registered.onlinePlayers() {
+			SendToAll(protocol.PlayerListItem{Name: player.Username(), Online: true, Ping: 50})
 		}
-	}()
+	})
 }
 
 func sendChunk(p Player, x, z int32, chunk *chunk.Chunk) {
@@ -147,13 +202,11 @@ func sendChunk(p Player, x, z int32, chunk *chunk.Chunk) {
 	} else {
 		p.SendPacketSync(protocol.ChunkAllocation{X: x, Z: z, Init: true})
 		p.SendPacketSync(chunk)
-		p.SendPacketSync(chunk.EntitySpawnPacket())
 	}
 }
 
-func SaveAllTheThings() {
-	SendToAll(protocol.Kick{Reason: "Server is shutting down!"})
-	storage.SaveAndUnloadAllChunks()
-	storage.SaveAllPlayers()
+// Kicks every player with the given reason. Each player is saved as they disconnect.
+func KickAll(reason string) {
+	SendToAll(protocol.Kick{Reason: reason})
 	time.Sleep(time.Second) // Give the kicks a little time to be recieved so the players get a useful message instead of "connection reset".
 }
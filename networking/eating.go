@@ -0,0 +1,95 @@
+package networking
+
+import (
+	"github.com/Nightgunner5/stuzzd/config"
+	"github.com/Nightgunner5/stuzzd/player"
+	"github.com/Nightgunner5/stuzzd/protocol"
+	"math"
+)
+
+// The client starts eating with a Player Block Placement that isn't against a block and stops early with a
+// Player Digging status. It waits for the server to say when the food has been eaten.
+
+const (
+	// How long eating takes.
+	eatTicks = 32
+
+	goldenApple  = 322 // Can be eaten with a full food bar
+	mushroomStew = 282 // Leaves its bowl behind
+	bowl         = 281
+)
+
+// Starts eating the held item if it is food and the player is hungry enough to eat it.
+func (p *_player) startEating() {
+	p.inventoryLock.Lock()
+	defer p.inventoryLock.Unlock()
+
+	p.eatingItem = 0
+	held := p.heldItem()
+	if held == nil {
+		return
+	}
+	if _, _, ok := player.FoodValue(held.Type); !ok {
+		return
+	}
+
+	p.statsLock.Lock()
+	hungry := p.stored.FoodLevel < player.MaxFood || held.Type == goldenApple
+	canEat := hungry && p.stored.Health > 0 && !p.stored.Abilities.Invulnerable
+	p.statsLock.Unlock()
+	if !canEat {
+		return
+	}
+
+	p.eatingItem, p.eatingSince = held.Type, config.CurrentTick()
+}
+
+func (p *_player) stopEating() {
+	p.inventoryLock.Lock()
+	defer p.inventoryLock.Unlock()
+
+	p.eatingItem = 0
+}
+
+// Finishes eating once the player has been at it long enough. Switching away from the food stops them eating.
+func (p *_player) tickEating() {
+	p.inventoryLock.Lock()
+	defer p.inventoryLock.Unlock()
+
+	if p.eatingItem == 0 || config.CurrentTick()-p.eatingSince < eatTicks {
+		return
+	}
+	item := p.eatingItem
+	p.eatingItem = 0
+
+	held := p.heldItem()
+	if held == nil || held.Type != item {
+		return
+	}
+	food, saturation, _ := player.FoodValue(item)
+
+	p.statsLock.Lock()
+	defer p.statsLock.Unlock()
+
+	stored := p.stored
+	if stored.Health == 0 {
+		return
+	}
+
+	slot := int8(p.heldSlot)
+	if item == mushroomStew {
+		stored.SetItem(slot, &player.InventoryItem{Type: bowl, Count: 1})
+	} else {
+		stored.RemoveItem(slot, 1)
+	}
+
+	stored.FoodLevel = uint32(math.Min(float64(stored.FoodLevel)+float64(food), player.MaxFood))
+	stored.FoodSaturationLevel = float32(math.Min(float64(stored.FoodSaturationLevel+float32(food)*saturation*2), float64(stored.FoodLevel)))
+
+	// The client uses up its own copy of the item when it is told it has eaten, and then gets the real one.
+	p.queuePacket(protocol.EntityStatus{ID: p.id, Status: protocol.EntityAte})
+	if pkt, ok := p.slotPacket(slot); ok {
+		p.queuePacket(pkt)
+	}
+	p.sendHealth()
+}
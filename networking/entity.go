@@ -9,7 +9,7 @@ import (
 
 type Entity interface {
 	ID() int32
-	SpawnPacket(io.Writer)
+	SpawnPacket(io.Writer) bool // Returns false if the entity can't be shown to clients
 	Position() (x, y, z float64)
 	SetPosition(x, y, z float64)
 }
@@ -25,22 +25,24 @@ func assignID() int32 {
 	return nextID
 }
 
-var entities = make(map[int32]Entity)
-var players = make(map[int32]Player)
-
 func RegisterEntity(ent Entity) {
-	entities[ent.ID()] = ent
-	if p, ok := ent.(Player); ok {
-		players[ent.ID()] = p
-	}
+	registered.add(ent)
 }
 
 func RemoveEntity(ent Entity) {
-	delete(entities, ent.ID())
-	if _, ok := ent.(Player); ok {
-		delete(players, ent.ID())
+	if registered.remove(ent) {
+		untrack(ent.ID())
 	}
-	SendToAll(protocol.DestroyEntity{ID: ent.ID()})
+}
+
+// Finds an online player by name, ignoring case. Returns nil if nobody by that name is online.
+func FindPlayer(name string) Player {
+	return registered.findPlayer(name)
+}
+
+// The number of players who are logged in or logging in.
+func OnlinePlayerCount() uint64 {
+	return registered.onlineCount()
 }
 
 func EntitySpawnPacket(ent Entity) protocol.Packet {
@@ -0,0 +1,199 @@
+package networking
+
+import (
+	"github.com/Nightgunner5/stuzzd/chunk"
+	"github.com/Nightgunner5/stuzzd/protocol"
+	"github.com/Nightgunner5/stuzzd/storage"
+	"math"
+)
+
+// Items disappear after five minutes.
+const itemDespawnAge = 5 * 60 * 20
+
+// Entities that fall this far below the world are removed.
+const voidDepth = -64
+
+// Movement properties of a kind of entity. Speeds are in blocks per tick.
+type physics struct {
+	gravity    float64
+	drag       float64 // Multiplier for motion each tick while in the air
+	groundDrag float64 // Multiplier for horizontal motion each tick while on the ground
+	height     float64
+}
+
+var itemPhysics = physics{gravity: 0.04, drag: 0.98, groundDrag: 0.98 * 0.6, height: 0.25}
+
+func solidAt(x, y, z float64) bool {
+	return !loadedBlockAt(int32(math.Floor(x)), int32(math.Floor(y)), int32(math.Floor(z))).Passable()
+}
+
+// Checks whether an entity with its feet at the given position would be inside a block.
+func (phys physics) blocked(x, y, z float64) bool {
+	return solidAt(x, y, z) || solidAt(x, y+phys.height-0.01, z)
+}
+
+// Advances an entity's position and motion by one tick, stopping it at any block it runs into.
+func (phys physics) step(x, y, z, mx, my, mz float64) (nx, ny, nz, nmx, nmy, nmz float64, onGround bool) {
+	if solidAt(x, y, z) {
+		// Something was placed on top of the entity. Push it out the top.
+		return x, math.Floor(y) + 1, z, 0, 0, 0, true
+	}
+
+	my -= phys.gravity
+
+	ny = y + my
+	if my < 0 && solidAt(x, ny, z) {
+		ny = math.Floor(ny) + 1
+		my = 0
+		onGround = true
+	} else if my > 0 && solidAt(x, ny+phys.height, z) {
+		ny = y
+		my = 0
+	}
+
+	nx, nz = x+mx, z+mz
+	if phys.blocked(nx, ny, z) {
+		nx, mx = x, 0
+	}
+	if phys.blocked(nx, ny, nz) {
+		nz, mz = z, 0
+	}
+
+	friction := phys.drag
+	if onGround {
+		friction = phys.groundDrag
+	}
+	mx, my, mz = mx*friction, my*phys.drag, mz*friction
+	if math.Abs(mx) < 0.001 {
+		mx = 0
+	}
+	if math.Abs(mz) < 0.001 {
+		mz = 0
+	}
+
+	return nx, ny, nz, mx, my, mz, onGround
+}
+
+// The last position sent to clients for each entity, in the 1/32 block units used by the protocol.
+// Only used by the ticker goroutine.
+var sentPositions = make(map[int32][3]int32)
+
+func encodePosition(x, y, z float64) [3]int32 {
+	return [3]int32{int32(x * 32), int32(y * 32), int32(z * 32)}
+}
+
+type tickedEntity struct {
+	c   *chunk.Chunk
+	ent chunk.Entity
+}
+
+func tickEntities() {
+	loaded := storage.LoadedChunks()
+
+	// Collect the entities first so an entity that moves to another chunk isn't ticked twice.
+	var ticked []tickedEntity
+	for _, c := range loaded {
+		for _, ent := range c.GetEntities() {
+			ticked = append(ticked, tickedEntity{c, ent})
+		}
+	}
+
+	sent := make(map[int32][3]int32, len(ticked))
+	for _, t := range ticked {
+		tickEntity(t.c, t.ent, sent)
+	}
+	sentPositions = sent
+	cleanUpMobs(sent)
+
+	for _, c := range loaded {
+		storage.ReleaseChunk(c.X, c.Z)
+	}
+}
+
+func tickEntity(c *chunk.Chunk, ent chunk.Entity, sent map[int32][3]int32) {
+	var phys physics
+	mob := ent.Mob()
+	switch {
+	case ent.Type() == "Item":
+		phys = itemPhysics
+	case mob != nil:
+		phys = mobKinds[mob.Type()].physics()
+	default:
+		return // TODO: other entity types
+	}
+
+	id := ent.ID()
+	x, y, z := ent.Position()
+	last, ok := sentPositions[id]
+	if !ok {
+		last = encodePosition(x, y, z)
+	}
+
+	age := ent.Age() + 1
+	if (ent.Type() == "Item" && age >= itemDespawnAge) || y < voidDepth {
+		despawnEntity(c, ent)
+		return
+	}
+
+	mx, my, mz := ent.Motion()
+	if mob != nil {
+		var yaw float32
+		var removed bool
+		mx, my, mz, yaw, removed = tickMob(c, mob, mobKinds[mob.Type()], mx, my, mz)
+		if removed {
+			return
+		}
+		if yaw != mob.Yaw() {
+			c.UpdateEntities(func() {
+				mob.SetYaw(yaw)
+			})
+			sendToObservers(id, protocol.EntityLook{ID: id, Yaw: yaw})
+			sendToObservers(id, protocol.EntityHeadLook{ID: id, Yaw: yaw})
+		}
+	}
+	x, y, z, mx, my, mz, onGround := phys.step(x, y, z, mx, my, mz)
+
+	c.UpdateEntities(func() {
+		ent.SetPosition(x, y, z)
+		ent.SetMotion(mx, my, mz)
+		ent.SetOnGround(onGround)
+		if drop := ent.ItemDrop(); drop != nil {
+			// Mobs keep their growing age in the same tag, so only items count up.
+			ent.SetAge(age)
+			if drop.PickupDelay() > 0 {
+				drop.SetPickupDelay(drop.PickupDelay() - 1)
+			}
+		}
+	})
+
+	chunkX, chunkZ := int32(math.Floor(x))>>4, int32(math.Floor(z))>>4
+	if chunkX != c.X || chunkZ != c.Z {
+		c.DespawnEntity(ent)
+		c = storage.GetChunk(chunkX, chunkZ)
+		c.SpawnEntity(ent)
+		storage.ReleaseChunk(chunkX, chunkZ)
+	}
+
+	sent[id] = sendEntityMove(c, id, last, x, y, z)
+}
+
+// Tells players tracking an entity that it has moved, returning the position that was sent.
+func sendEntityMove(c *chunk.Chunk, id int32, last [3]int32, x, y, z float64) [3]int32 {
+	now := encodePosition(x, y, z)
+	if now == last {
+		return last
+	}
+
+	dx, dy, dz := now[0]-last[0], now[1]-last[1], now[2]-last[2]
+	if -128 <= dx && dx <= 127 && -128 <= dy && dy <= 127 && -128 <= dz && dz <= 127 {
+		sendToObservers(id, protocol.EntityRelativeMove{ID: id, X: int8(dx), Y: int8(dy), Z: int8(dz)})
+	} else {
+		sendToObservers(id, protocol.EntityTeleport{ID: id, X: x, Y: y, Z: z})
+	}
+	return now
+}
+
+func despawnEntity(c *chunk.Chunk, ent chunk.Entity) {
+	c.DespawnEntity(ent)
+	untrack(ent.ID())
+}
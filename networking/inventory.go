@@ -0,0 +1,342 @@
+package networking
+
+import (
+	"github.com/Nightgunner5/stuzzd/player"
+	"github.com/Nightgunner5/stuzzd/protocol"
+	"math"
+)
+
+// Window 0 is the player's own inventory. Its slots map onto the stored inventory like this:
+//
+//	0     crafting output (not stored)
+//	1-4   crafting grid (not stored)
+//	5-8   armor, from helmet to boots
+//	9-35  main inventory
+//	36-44 hotbar
+const (
+	windowSlotCount   = 45
+	windowSlotOutside = -999
+)
+
+func windowToStorageSlot(slot int16) (int8, bool) {
+	switch {
+	case 5 <= slot && slot <= 8:
+		return player.SlotHelmet - int8(slot-5), true
+	case 9 <= slot && slot <= 35:
+		return int8(slot), true
+	case 36 <= slot && slot <= 44:
+		return int8(slot - 36), true
+	}
+	return 0, false
+}
+
+func storageToWindowSlot(slot int8) (int16, bool) {
+	switch {
+	case player.SlotBoots <= slot && slot <= player.SlotHelmet:
+		return int16(player.SlotHelmet-slot) + 5, true
+	case player.SlotMainStart <= slot && slot <= player.SlotMainEnd:
+		return int16(slot), true
+	case player.SlotHotbarStart <= slot && slot <= player.SlotHotbarEnd:
+		return int16(slot) + 36, true
+	}
+	return 0, false
+}
+
+func isArmorSlot(slot int8) bool {
+	return player.SlotBoots <= slot && slot <= player.SlotHelmet
+}
+
+func slotRange(start, end int8) []int8 {
+	slots := make([]int8, 0, end-start+1)
+	for slot := start; slot <= end; slot++ {
+		slots = append(slots, slot)
+	}
+	return slots
+}
+
+func itemToSlot(item *player.InventoryItem) protocol.Slot {
+	if item == nil {
+		return protocol.EmptySlot
+	}
+	return protocol.Slot{ID: item.Type, Count: item.Count, Damage: item.Damage, Meta: item.Meta}
+}
+
+func slotToItem(slot protocol.Slot) *player.InventoryItem {
+	if slot.ID <= 0 || slot.Count <= 0 {
+		return nil
+	}
+	return &player.InventoryItem{Type: slot.ID, Count: slot.Count, Damage: slot.Damage, Meta: slot.Meta}
+}
+
+func sameItem(a, b *player.InventoryItem) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Type == b.Type && a.Damage == b.Damage && a.Count == b.Count
+}
+
+// The caller must hold the player's inventory lock.
+func (p *_player) itemCopy(slot int8) *player.InventoryItem {
+	if item := p.stored.GetItem(slot); item != nil {
+		c := *item
+		return &c
+	}
+	return nil
+}
+
+// The caller must hold the player's inventory lock.
+func (p *_player) heldItem() *player.InventoryItem {
+	return p.stored.GetItem(int8(p.heldSlot))
+}
+
+// Sends every slot in the player's inventory window and the item on their cursor. Anything sent while holding
+// the inventory lock goes through the ordered queue, because the entity tracker takes the lock to see what the
+// player is holding and mustn't wait on a slow connection.
+// The caller must hold the player's inventory lock.
+func (p *_player) sendInventory() {
+	items := make([]protocol.Slot, windowSlotCount)
+	for i := range items {
+		items[i] = protocol.EmptySlot
+		if slot, ok := windowToStorageSlot(int16(i)); ok {
+			items[i] = itemToSlot(p.stored.GetItem(slot))
+		}
+	}
+	p.queuePacket(protocol.WindowItems{WindowID: 0, Items: items})
+	p.queuePacket(protocol.SetSlot{WindowID: -1, Slot: -1, Item: itemToSlot(p.cursor)})
+}
+
+// Returns the packet that updates a slot on the client, or false if the slot isn't in the inventory window.
+// The caller must hold the player's inventory lock.
+func (p *_player) slotPacket(slot int8) (protocol.SetSlot, bool) {
+	windowSlot, ok := storageToWindowSlot(slot)
+	return protocol.SetSlot{WindowID: 0, Slot: windowSlot, Item: itemToSlot(p.stored.GetItem(slot))}, ok
+}
+
+// Merges as much of item as possible into the given slots, first onto matching stacks and then into empty
+// slots. The item's count is reduced by the amount stored. Returns the slots that were changed.
+// The caller must hold the player's inventory lock.
+func (p *_player) mergeItem(item *player.InventoryItem, slots []int8) []int8 {
+	var changed []int8
+	for _, slot := range slots {
+		if item.Count <= 0 {
+			return changed
+		}
+		existing := p.stored.GetItem(slot)
+		if existing == nil || !existing.StacksWith(item) || existing.Count >= existing.MaxStack() {
+			continue
+		}
+		move := existing.MaxStack() - existing.Count
+		if move > item.Count {
+			move = item.Count
+		}
+		existing.Count += move
+		item.Count -= move
+		changed = append(changed, slot)
+	}
+	for _, slot := range slots {
+		if item.Count <= 0 {
+			return changed
+		}
+		if p.stored.GetItem(slot) != nil {
+			continue
+		}
+		stack := *item
+		if stack.Count > stack.MaxStack() {
+			stack.Count = stack.MaxStack()
+		}
+		p.stored.SetItem(slot, &stack)
+		item.Count -= stack.Count
+		changed = append(changed, slot)
+	}
+	return changed
+}
+
+// Stores an item in the hotbar or main inventory, returning the slots that were changed. Anything that does not
+// fit is left in item.
+// The caller must hold the player's inventory lock.
+func (p *_player) storeItem(item *player.InventoryItem) []int8 {
+	return p.mergeItem(item, append(slotRange(player.SlotHotbarStart, player.SlotHotbarEnd), slotRange(player.SlotMainStart, player.SlotMainEnd)...))
+}
+
+// Throws an item in the direction the player is looking.
+func (p *_player) dropItem(item *player.InventoryItem) {
+	x, y, z := p.Position()
+	yaw, _ := p.Angles()
+	rad := float64(yaw) * math.Pi / 180
+	dropStack(x-math.Sin(rad), y+1.3, z+math.Cos(rad), item, 40)
+}
+
+// Puts the item on the player's cursor back into their inventory, dropping anything that does not fit.
+// The caller must hold the player's inventory lock.
+func (p *_player) returnCursor() {
+	if p.cursor == nil {
+		return
+	}
+	p.storeItem(p.cursor)
+	if p.cursor.Count > 0 {
+		p.dropItem(p.cursor)
+	}
+	p.cursor = nil
+}
+
+func (p *_player) clickWindow(pkt protocol.WindowClick) {
+	p.inventoryLock.Lock()
+	defer p.inventoryLock.Unlock()
+
+	accepted := pkt.WindowID == 0 && p.applyClick(pkt)
+	p.queuePacket(protocol.Transaction{WindowID: pkt.WindowID, Action: pkt.Action, Accepted: accepted})
+	if !accepted {
+		p.sendInventory()
+	}
+}
+
+// The caller must hold the player's inventory lock.
+func (p *_player) applyClick(pkt protocol.WindowClick) bool {
+	if pkt.Slot == windowSlotOutside {
+		if p.cursor != nil {
+			drop := *p.cursor
+			if pkt.RightClick {
+				drop.Count = 1
+			}
+			p.cursor.Count -= drop.Count
+			if p.cursor.Count <= 0 {
+				p.cursor = nil
+			}
+			p.dropItem(&drop)
+		}
+		return true
+	}
+
+	slot, ok := windowToStorageSlot(pkt.Slot)
+	if !ok {
+		return false // TODO: crafting
+	}
+
+	here := p.itemCopy(slot)
+	if !sameItem(here, slotToItem(pkt.Item)) {
+		return false
+	}
+
+	if pkt.Shift {
+		if here == nil {
+			return true
+		}
+		var targets []int8
+		switch {
+		case player.SlotHotbarStart <= slot && slot <= player.SlotHotbarEnd:
+			targets = slotRange(player.SlotMainStart, player.SlotMainEnd)
+		case player.SlotMainStart <= slot && slot <= player.SlotMainEnd:
+			targets = slotRange(player.SlotHotbarStart, player.SlotHotbarEnd)
+		default:
+			targets = append(slotRange(player.SlotMainStart, player.SlotMainEnd), slotRange(player.SlotHotbarStart, player.SlotHotbarEnd)...)
+		}
+		p.mergeItem(here, targets)
+		p.stored.SetItem(slot, here)
+		return true
+	}
+
+	limit := int8(0)
+	if p.cursor != nil {
+		limit = p.cursor.MaxStack()
+		if isArmorSlot(slot) {
+			if !player.FitsArmorSlot(p.cursor.Type, slot) {
+				return false
+			}
+			limit = 1
+		}
+	}
+
+	switch {
+	case p.cursor == nil && here == nil:
+		return true
+
+	case p.cursor == nil:
+		take := here.Count
+		if pkt.RightClick {
+			take = (here.Count + 1) / 2
+		}
+		picked := *here
+		picked.Count = take
+		here.Count -= take
+		p.cursor = &picked
+
+	case here == nil || here.StacksWith(p.cursor):
+		count := int8(0)
+		if here != nil {
+			count = here.Count
+		} else {
+			placed := *p.cursor
+			placed.Count = 0
+			here = &placed
+		}
+		move := limit - count
+		if pkt.RightClick && move > 1 {
+			move = 1
+		}
+		if move > p.cursor.Count {
+			move = p.cursor.Count
+		}
+		if move <= 0 {
+			return true
+		}
+		here.Count += move
+		p.cursor.Count -= move
+		if p.cursor.Count <= 0 {
+			p.cursor = nil
+		}
+
+	default:
+		if p.cursor.Count > limit {
+			return false
+		}
+		here, p.cursor = p.cursor, here
+	}
+
+	p.stored.SetItem(slot, here)
+	return true
+}
+
+func (p *_player) creativeInventoryAction(pkt protocol.CreativeInventoryAction) {
+	p.inventoryLock.Lock()
+	defer p.inventoryLock.Unlock()
+
+	if !p.stored.Abilities.InstaBuild {
+		p.sendInventory()
+		return
+	}
+
+	item := slotToItem(pkt.Item)
+	if item != nil && item.Count > item.MaxStack() {
+		item.Count = item.MaxStack()
+	}
+
+	if pkt.Slot == -1 {
+		if item != nil {
+			p.dropItem(item)
+		}
+		return
+	}
+
+	if slot, ok := windowToStorageSlot(pkt.Slot); ok {
+		p.stored.SetItem(slot, item)
+	}
+}
+
+func (p *_player) setHeldSlot(slot int16) {
+	if slot < 0 || slot > int16(player.SlotHotbarEnd) {
+		return
+	}
+
+	p.inventoryLock.Lock()
+	defer p.inventoryLock.Unlock()
+
+	p.heldSlot = slot
+	p.eatingItem = 0
+}
+
+func (p *_player) closeWindow() {
+	p.inventoryLock.Lock()
+	defer p.inventoryLock.Unlock()
+
+	p.returnCursor()
+}
@@ -0,0 +1,242 @@
+package networking
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Nightgunner5/stuzzd/protocol"
+	"log"
+	"strings"
+	"sync"
+)
+
+// Sends a chat message from sender to every online player who isn't ignoring them.
+func broadcastChat(sender CommandSender, message string) {
+	baked := protocol.BakePacket(protocol.Chat{Message: message})
+	for _, p := range registered.onlinePlayers() {
+		if !p.(*_player).isIgnoring(sender) {
+			go p.SendPacketSync(baked)
+		}
+	}
+}
+
+func (p *_player) isIgnoring(sender CommandSender) bool {
+	if sender == Console {
+		return false
+	}
+
+	p.chatLock.Lock()
+	defer p.chatLock.Unlock()
+
+	name := strings.ToLower(sender.Username())
+	for _, ignored := range p.stored.StuzzDIgnored {
+		if ignored == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns false if the player was already ignoring name.
+func (p *_player) ignore(name string) bool {
+	if p.isIgnoring(offlineSender(name)) {
+		return false
+	}
+
+	p.chatLock.Lock()
+	defer p.chatLock.Unlock()
+
+	p.stored.StuzzDIgnored = append(p.stored.StuzzDIgnored, strings.ToLower(name))
+	return true
+}
+
+// Returns false if the player wasn't ignoring name.
+func (p *_player) unignore(name string) bool {
+	p.chatLock.Lock()
+	defer p.chatLock.Unlock()
+
+	name = strings.ToLower(name)
+	for i, ignored := range p.stored.StuzzDIgnored {
+		if ignored == name {
+			p.stored.StuzzDIgnored = append(p.stored.StuzzDIgnored[:i], p.stored.StuzzDIgnored[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (p *_player) ignored() []string {
+	p.chatLock.Lock()
+	defer p.chatLock.Unlock()
+
+	return append([]string(nil), p.stored.StuzzDIgnored...)
+}
+
+var (
+	replyLock sync.Mutex
+	replyTo   = make(map[string]string) // Lowercase username to the username /r sends to
+)
+
+// Finds who a private message to name goes to. "console" is the server console.
+func findRecipient(name string) CommandSender {
+	if strings.EqualFold(name, Console.Username()) {
+		return Console
+	}
+	if p := FindPlayer(name); p != nil {
+		return p
+	}
+	return nil
+}
+
+func sendPrivateMessage(from, to CommandSender, message string) error {
+	if err := checkMuted(from); err != nil {
+		return err
+	}
+	message = filterChat(message)
+
+	if from != Console && to != Console {
+		// The console sees its own messages as chat.
+		log.Printf("[%s -> %s] %s", from.Username(), to.Username(), message)
+	}
+
+	sendChat(from, fmt.Sprintf("%s[me -> %s%s] %s%s", ChatInfo, formatUsername(to), ChatInfo, ChatPayload, message))
+
+	replyLock.Lock()
+	replyTo[strings.ToLower(from.Username())] = to.Username()
+	replyTo[strings.ToLower(to.Username())] = from.Username()
+	replyLock.Unlock()
+
+	// The sender isn't told they are being ignored.
+	if p, ok := to.(*_player); !ok || !p.isIgnoring(from) {
+		sendChat(to, fmt.Sprintf("%s[%s%s -> me] %s%s", ChatInfo, formatUsername(from), ChatInfo, ChatPayload, message))
+	}
+
+	spied := protocol.BakePacket(protocol.Chat{Message: fmt.Sprintf("%s[Spy] %s -> %s: %s", ChatInfo, from.Username(), to.Username(), message)})
+	for _, p := range registered.onlinePlayers() {
+		if p == from || p == to {
+			continue
+		}
+		spy := p.(*_player)
+		spy.chatLock.Lock()
+		spying := spy.socialSpy
+		spy.chatLock.Unlock()
+		if spying {
+			go spy.SendPacketSync(spied)
+		}
+	}
+	return nil
+}
+
+func init() {
+	RegisterCommand(&Command{
+		Name:        "msg",
+		Aliases:     []string{"tell", "w", "whisper"},
+		Description: "Send a private message.",
+		Args:        []Arg{{Name: "player", Type: ArgWord}, {Name: "message", Type: ArgRest}},
+		Quiet:       true,
+		Run: func(sender CommandSender, args Args) error {
+			to := findRecipient(args.String(0))
+			if to == nil {
+				return fmt.Errorf("Could not find player %s.", args.String(0))
+			}
+			if to == sender {
+				return errors.New("You can't send a message to yourself.")
+			}
+
+			return sendPrivateMessage(sender, to, args.String(1))
+		},
+	})
+	RegisterCommand(&Command{
+		Name:        "r",
+		Aliases:     []string{"reply"},
+		Description: "Reply to the last private message.",
+		Args:        []Arg{{Name: "message", Type: ArgRest}},
+		Quiet:       true,
+		Run: func(sender CommandSender, args Args) error {
+			replyLock.Lock()
+			name, ok := replyTo[strings.ToLower(sender.Username())]
+			replyLock.Unlock()
+			if !ok {
+				return errors.New("Nobody has sent you a message.")
+			}
+
+			to := findRecipient(name)
+			if to == nil {
+				return fmt.Errorf("%s is no longer online.", name)
+			}
+
+			return sendPrivateMessage(sender, to, args.String(0))
+		},
+	})
+	RegisterCommand(&Command{
+		Name:        "ignore",
+		Description: "Hide a player's chat from you, or list who you are ignoring.",
+		Args:        []Arg{{Name: "player", Type: ArgWord, Optional: true}},
+		Quiet:       true,
+		Run: func(sender CommandSender, args Args) error {
+			self, ok := sender.(*_player)
+			if !ok {
+				return ErrNotPlayer
+			}
+
+			if !args.Has(0) {
+				sendChat(sender, ChatInfo+"You are ignoring: "+ChatPayload+strings.Join(self.ignored(), ", "))
+				return nil
+			}
+
+			name := args.String(0)
+			if strings.EqualFold(name, self.Username()) {
+				return errors.New("You can't ignore yourself.")
+			}
+			if strings.EqualFold(name, Console.Username()) {
+				return errors.New("You can't ignore the console.")
+			}
+			if !self.ignore(name) {
+				return errors.New("You are already ignoring that player.")
+			}
+			sendChat(sender, ChatInfo+"You are now ignoring "+formatUsernameOffline(name)+".")
+			return nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:        "unignore",
+		Description: "Stop ignoring a player.",
+		Args:        []Arg{{Name: "player", Type: ArgWord}},
+		Quiet:       true,
+		Run: func(sender CommandSender, args Args) error {
+			self, ok := sender.(*_player)
+			if !ok {
+				return ErrNotPlayer
+			}
+
+			if !self.unignore(args.String(0)) {
+				return errors.New("You aren't ignoring that player.")
+			}
+			sendChat(sender, ChatInfo+"You are no longer ignoring "+formatUsernameOffline(args.String(0))+".")
+			return nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:        "socialspy",
+		Description: "Toggle seeing everyone's private messages.",
+		Permission:  "stuzzd.command.socialspy",
+		Run: func(sender CommandSender, args Args) error {
+			self, ok := sender.(*_player)
+			if !ok {
+				// The console already sees private messages in the log.
+				return ErrNotPlayer
+			}
+
+			self.chatLock.Lock()
+			self.socialSpy = !self.socialSpy
+			spying := self.socialSpy
+			self.chatLock.Unlock()
+
+			if spying {
+				sendChat(sender, ChatInfo+"You can now see private messages.")
+			} else {
+				sendChat(sender, ChatInfo+"You can no longer see private messages.")
+			}
+			return nil
+		},
+	})
+}
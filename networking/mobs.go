@@ -0,0 +1,370 @@
+package networking
+
+import (
+	"github.com/Nightgunner5/stuzzd/block"
+	"github.com/Nightgunner5/stuzzd/chunk"
+	"github.com/Nightgunner5/stuzzd/config"
+	"github.com/Nightgunner5/stuzzd/pathfind"
+	"github.com/Nightgunner5/stuzzd/player"
+	"github.com/Nightgunner5/stuzzd/protocol"
+	"github.com/Nightgunner5/stuzzd/scheduler"
+	"github.com/Nightgunner5/stuzzd/storage"
+	"math"
+	"math/rand"
+	"sync"
+)
+
+// Mobs are chunk entities like dropped items, and are moved by the same ticker. Everything about a mob is
+// changed on the ticker's goroutine, so hits from players are queued and applied on the mob's next tick.
+
+type mobKind struct {
+	height float64
+	speed  float64 // Walking speed, in blocks per tick
+	drops  func(chunk.Mob) []player.InventoryItem
+
+	hostile         bool
+	burnsInDaylight bool
+	attack          attackStyle
+	damage          int // Before it is changed for the difficulty
+}
+
+var mobKinds = map[string]mobKind{
+	"Pig":     {height: 0.9, speed: 0.1, drops: dropSome(319)}, // Raw porkchop
+	"Sheep":   {height: 1.3, speed: 0.1, drops: dropWool},
+	"Cow":     {height: 1.3, speed: 0.08, drops: dropSome(334)}, // Leather
+	"Chicken": {height: 0.4, speed: 0.1, drops: dropSome(288)},  // Feather
+
+	"Zombie":   {height: 1.8, speed: 0.1, drops: dropSome(367), hostile: true, burnsInDaylight: true, attack: attackMelee, damage: 4}, // Rotten flesh
+	"Skeleton": {height: 1.8, speed: 0.1, drops: dropSkeleton, hostile: true, burnsInDaylight: true, attack: attackRanged, damage: 3},
+	"Spider":   {height: 0.9, speed: 0.13, drops: dropSome(287), hostile: true, attack: attackMelee, damage: 2}, // String
+	"Creeper":  {height: 1.7, speed: 0.1, drops: dropSome(289), hostile: true, attack: attackExplode},           // Gunpowder
+}
+
+// The kinds of mob that spawn on grass.
+var animals = []string{"Pig", "Sheep", "Cow", "Chicken"}
+
+const (
+	// How often a standing mob starts wandering, as one in this many ticks.
+	wanderChance = 120
+	// How long a mob runs for after it is hit, and how much faster than walking.
+	panicTicks = 60
+	panicSpeed = 1.5
+
+	mobJumpSpeed      = 0.42
+	mobHurtTicks      = 10 // Mobs can't be hurt again for this long after a hit.
+	mobKnockbackTicks = 10 // Mobs don't try to walk for this long after a hit.
+	mobDeathTicks     = 20 // How long the death animation plays before the body is removed.
+	mobMaxDropDown    = 3  // Wandering mobs won't walk off anything higher than this.
+
+	// Every animalSpawnInterval ticks, each loaded chunk has a one in animalSpawnChance chance of getting a pack
+	// of animals if it has room for them. Animals don't spawn within animalSpawnDistance blocks of a player.
+	animalSpawnInterval = 400
+	animalSpawnChance   = 20
+	animalSpawnDistance = 24
+	maxPackSize         = 4
+)
+
+func (kind mobKind) physics() physics {
+	return physics{gravity: 0.08, drag: 0.98, groundDrag: 0.91 * 0.6, height: kind.height}
+}
+
+func dropSome(item int16) func(chunk.Mob) []player.InventoryItem {
+	return func(chunk.Mob) []player.InventoryItem {
+		if count := rand.Intn(3); count > 0 {
+			return []player.InventoryItem{{Type: item, Count: int8(count)}}
+		}
+		return nil
+	}
+}
+
+func dropWool(m chunk.Mob) []player.InventoryItem {
+	if m.Sheared() {
+		return nil
+	}
+	return []player.InventoryItem{{Type: int16(block.Wool), Damage: int16(m.Color()), Count: 1}}
+}
+
+func dropSkeleton(m chunk.Mob) []player.InventoryItem {
+	return append(dropSome(262)(m), dropSome(352)(m)...) // Arrows and bones
+}
+
+// What a mob is doing. Only used by the ticker goroutine.
+type mobState struct {
+	// Wandering, or running away after being hit
+	dx, dz float64
+	speed  float64
+	ticks  int // How much longer the mob keeps going
+
+	knockback int // Ticks left before the mob tries to walk again
+
+	// Hunting players
+	target   *_player
+	path     []pathfind.Point
+	pathTick uint64 // When to look for a new path
+	cooldown int    // Ticks until the mob can attack again
+	fuse     int    // How long a creeper has been about to explode
+}
+
+var mobStates = make(map[int32]*mobState)
+
+type mobHit struct {
+	attacker *_player
+	damage   int
+	tick     uint64
+}
+
+var (
+	hitLock     sync.Mutex
+	pendingHits = make(map[int32]mobHit)
+)
+
+// A mob as a target for players. Only the ID is kept so the player's goroutine never reads the mob itself.
+type mobTarget int32
+
+// Queues the hit for the mob's next tick, which charges the attacker's exhaustion if the hit lands. Always
+// returns false, as nothing has happened yet.
+func (id mobTarget) attacked(attacker *_player, damage int) bool {
+	hitLock.Lock()
+	defer hitLock.Unlock()
+
+	pendingHits[int32(id)] = mobHit{attacker, damage, config.CurrentTick()}
+	return false
+}
+
+// Runs a mob's AI for one tick, returning its new motion and which way it should face.
+// Dead mobs lie still until their death animation finishes and then drop their items and disappear.
+func tickMob(c *chunk.Chunk, mob chunk.Mob, kind mobKind, mx, my, mz float64) (nmx, nmy, nmz float64, yaw float32, removed bool) {
+	id := chunk.Entity(mob).ID()
+	x, y, z := chunk.Entity(mob).Position()
+	yaw = mob.Yaw()
+
+	hitLock.Lock()
+	hit, wasHit := pendingHits[id]
+	delete(pendingHits, id)
+	hitLock.Unlock()
+
+	if deathTime := mob.DeathTime(); deathTime > 0 {
+		if deathTime >= mobDeathTicks {
+			for _, item := range kind.drops(mob) {
+				item := item
+				dropStack(x, y+0.5, z, &item, 10)
+			}
+			removeMob(c, mob)
+			return mx, my, mz, yaw, true
+		}
+		c.UpdateEntities(func() {
+			mob.SetDeathTime(deathTime + 1)
+		})
+		return 0, my, 0, yaw, false
+	}
+
+	if kind.hostile && shouldDespawn(x, y, z) {
+		removeMob(c, mob)
+		return mx, my, mz, yaw, true
+	}
+
+	state := mobStates[id]
+	if state == nil {
+		state = new(mobState)
+		mobStates[id] = state
+	}
+
+	if burnMob(c, mob, kind, x, y, z) {
+		return 0, my, 0, yaw, false
+	}
+
+	if hurtTime := mob.HurtTime(); hurtTime > 0 {
+		c.UpdateEntities(func() {
+			mob.SetHurtTime(hurtTime - 1)
+		})
+	} else if wasHit {
+		ax, ay, az := hit.attacker.Position()
+		if (x-ax)*(x-ax)+(y-ay)*(y-ay)+(z-az)*(z-az) <= maxReach*maxReach {
+			hit.attacker.exhaustFromAttack()
+			if damageMob(c, mob, hit.damage) {
+				return 0, my, 0, yaw, false
+			}
+
+			var vy float64
+			mx, vy, mz = knockback(x, z, ax, az)
+			my += vy
+			state.knockback = mobKnockbackTicks
+
+			if kind.hostile {
+				// Fight back.
+				state.target = hit.attacker
+			} else {
+				// Run away from whatever hit it.
+				state.dx, state.dz = mx/knockbackStrength, mz/knockbackStrength
+				state.speed = kind.speed * panicSpeed
+				state.ticks = panicTicks
+			}
+			return mx, my, mz, yaw, false
+		}
+	}
+
+	if state.knockback > 0 {
+		// Still flying from the last hit.
+		state.knockback--
+		return mx, my, mz, yaw, false
+	}
+
+	if kind.hostile {
+		var hunting bool
+		mx, my, mz, yaw, hunting, removed = hunt(c, mob, kind, state, x, y, z, my)
+		if hunting || removed {
+			return mx, my, mz, yaw, removed
+		}
+	}
+
+	if state.ticks <= 0 {
+		if rand.Intn(wanderChance) != 0 {
+			return mx, my, mz, yaw, false
+		}
+		angle := rand.Float64() * 2 * math.Pi
+		state.dx, state.dz = math.Cos(angle), math.Sin(angle)
+		state.speed = kind.speed
+		state.ticks = 20 + rand.Intn(60)
+	}
+	state.ticks--
+
+	my, ok := mobStep(mob, kind, x, y, z, state.dx, state.dz, my)
+	if !ok {
+		state.ticks = 0
+		return mx, my, mz, yaw, false
+	}
+	return state.dx * state.speed, my, state.dz * state.speed, faceDirection(state.dx, state.dz), false
+}
+
+// Checks that a mob at x, y, z can walk in the direction dx, dz, returning its vertical motion with a jump added
+// if there is a block in the way.
+func mobStep(mob chunk.Mob, kind mobKind, x, y, z, dx, dz, my float64) (float64, bool) {
+	aheadX, aheadZ := x+dx*0.6, z+dz*0.6
+	if !safeToWalk(aheadX, y, aheadZ) {
+		return my, false
+	}
+	if kind.physics().blocked(aheadX, y, aheadZ) && chunk.Entity(mob).OnGround() {
+		my = mobJumpSpeed
+	}
+	return my, true
+}
+
+// The yaw for something facing in the direction dx, dz.
+func faceDirection(dx, dz float64) float32 {
+	return float32(math.Atan2(-dx, dz) * 180 / math.Pi)
+}
+
+// Hurts a mob, killing it if it runs out of health. Returns true if it died.
+func damageMob(c *chunk.Chunk, mob chunk.Mob, damage int) bool {
+	id := chunk.Entity(mob).ID()
+	health := mob.Health() - int16(damage)
+	status := protocol.EntityHurt
+	if health <= 0 {
+		health, status = 0, protocol.EntityDead
+	}
+	c.UpdateEntities(func() {
+		mob.SetHealth(health)
+		mob.SetHurtTime(mobHurtTicks)
+		if health == 0 {
+			mob.SetDeathTime(1)
+		}
+	})
+	sendToObservers(id, protocol.EntityStatus{ID: id, Status: status})
+	return health == 0
+}
+
+func removeMob(c *chunk.Chunk, mob chunk.Mob) {
+	delete(mobStates, chunk.Entity(mob).ID())
+	despawnEntity(c, chunk.Entity(mob))
+}
+
+// Reports whether a mob can walk to x, z at height y without stepping into lava or falling too far.
+func safeToWalk(x, y, z float64) bool {
+	blockX, blockY, blockZ := int32(math.Floor(x)), int32(math.Floor(y)), int32(math.Floor(z))
+	for dy := int32(0); dy <= mobMaxDropDown; dy++ {
+		below := loadedBlockAt(blockX, blockY-dy, blockZ)
+		if below.IsLava() || loadedBlockAt(blockX, blockY+1-dy, blockZ).IsLava() {
+			return false
+		}
+		if !below.Passable() || below.IsWater() {
+			return true
+		}
+	}
+	return false
+}
+
+// Forgets the AI state of mobs that weren't ticked (because they died or their chunk was unloaded) and
+// hits that nothing picked up (because they were on something that isn't a mob).
+func cleanUpMobs(ticked map[int32][3]int32) {
+	for id := range mobStates {
+		if _, ok := ticked[id]; !ok {
+			delete(mobStates, id)
+		}
+	}
+
+	hitLock.Lock()
+	for id, hit := range pendingHits {
+		if config.CurrentTick()-hit.tick > 1 {
+			delete(pendingHits, id)
+		}
+	}
+	hitLock.Unlock()
+}
+
+func spawnAnimals() {
+	if config.Config.AnimalsPerChunk <= 0 {
+		return
+	}
+
+	for _, c := range storage.LoadedChunks() {
+		if rand.Intn(animalSpawnChance) == 0 {
+			spawnAnimalsIn(c)
+		}
+		storage.ReleaseChunk(c.X, c.Z)
+	}
+}
+
+// Spawns a pack of one kind of animal on the grass in a chunk, without going over the chunk's limit.
+func spawnAnimalsIn(c *chunk.Chunk) {
+	room := config.Config.AnimalsPerChunk
+	for _, ent := range c.GetEntities() {
+		if mob := ent.Mob(); mob != nil && !mobKinds[mob.Type()].hostile {
+			room--
+		}
+	}
+	if room <= 0 {
+		return
+	}
+
+	kind := animals[rand.Intn(len(animals))]
+	pack := 1 + rand.Intn(maxPackSize)
+	if pack > room {
+		pack = room
+	}
+
+	online := registered.onlinePlayers()
+	for i := 0; i < pack; i++ {
+		x, z := c.X<<4|int32(rand.Intn(16)), c.Z<<4|int32(rand.Intn(16))
+		y := c.GetHighestBlockYAt(x, z)
+		if c.GetBlock(x, y, z) != block.Grass || nearPlayer(online, float64(x), float64(z), animalSpawnDistance) {
+			continue
+		}
+		mob := chunk.NewMob(kind, float64(x)+0.5, float64(y+1), float64(z)+0.5, rand.Float32()*360)
+		c.SpawnEntity(chunk.Entity(mob))
+		// The entity tracker will spawn it for nearby players on the next tick.
+	}
+}
+
+func nearPlayer(online []Player, x, z, distance float64) bool {
+	for _, p := range online {
+		px, _, pz := p.Position()
+		if (px-x)*(px-x)+(pz-z)*(pz-z) < distance*distance {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	scheduler.Every(animalSpawnInterval, "animal spawning", spawnAnimals)
+}
@@ -0,0 +1,367 @@
+package networking
+
+import (
+	"github.com/Nightgunner5/stuzzd/block"
+	"github.com/Nightgunner5/stuzzd/chunk"
+	"github.com/Nightgunner5/stuzzd/config"
+	"github.com/Nightgunner5/stuzzd/pathfind"
+	"github.com/Nightgunner5/stuzzd/protocol"
+	"github.com/Nightgunner5/stuzzd/scheduler"
+	"github.com/Nightgunner5/stuzzd/storage"
+	"math"
+	"math/rand"
+)
+
+// Hostile mobs spawn in the dark, chase the nearest player they can reach and attack when they get close.
+// None of this happens on peaceful, and any hostile mobs left in the world disappear.
+
+type attackStyle uint8
+
+const (
+	attackMelee attackStyle = iota
+	// Skeletons hit from a distance when they can see their target. They don't fire arrow entities.
+	attackRanged
+	// Creepers stop, hiss and explode. The explosion hurts players but doesn't break blocks.
+	attackExplode
+)
+
+// The kinds of mob that spawn in the dark.
+var monsters = []string{"Zombie", "Skeleton", "Spider", "Creeper"}
+
+const (
+	huntRange      = 16 // How close a player has to be for a mob to start chasing them
+	loseRange      = 32 // How far away a player has to get for a mob to give up
+	huntSpeed      = 2  // How much faster than walking mobs chase players
+	repathInterval = 20 // Ticks between searches for a path to the target
+
+	meleeReach    = 2
+	meleeCooldown = 20
+
+	rangedReach    = 10
+	rangedCooldown = 60
+
+	fuseReach       = 3  // Creepers start hissing this close to their target
+	fuseCancelReach = 7  // and calm down if the target gets this far away.
+	fuseTicks       = 30 // How long creepers hiss before they explode
+	creeperRadius   = 3
+
+	daylightBurnTime = 160
+
+	// Every monsterSpawnInterval ticks, each loaded chunk has a one in monsterSpawnChance chance of getting a pack
+	// of monsters if it has room for them. They need a sky light of 7 or less.
+	monsterSpawnInterval = 20
+	monsterSpawnChance   = 10
+	monsterSpawnDistance = 24
+	maxMonsterLight      = 7
+
+	// Monsters further than this from every player disappear. Monsters further than despawnRange have a one in
+	// despawnChance chance of disappearing each tick.
+	forceDespawnRange = 128
+	despawnRange      = 32
+	despawnChance     = 800
+)
+
+func peaceful() bool {
+	return protocol.Difficulty(config.CurrentDifficulty()) == protocol.Peaceful
+}
+
+// Changes how much damage a mob does to a player for the difficulty, like vanilla.
+func scaleForDifficulty(damage int) int {
+	switch protocol.Difficulty(config.CurrentDifficulty()) {
+	case protocol.Peaceful:
+		return 0
+	case protocol.Easy:
+		return damage/2 + 1
+	case protocol.Hard:
+		return damage * 3 / 2
+	}
+	return damage
+}
+
+// Reports whether a hostile mob at x, y, z should be removed because it is too far from every player, or
+// because it is peaceful.
+func shouldDespawn(x, y, z float64) bool {
+	if peaceful() {
+		return true
+	}
+
+	closest := math.Inf(1)
+	for _, p := range registered.onlinePlayers() {
+		px, py, pz := p.Position()
+		if d := (px-x)*(px-x) + (py-y)*(py-y) + (pz-z)*(pz-z); d < closest {
+			closest = d
+		}
+	}
+	if closest > forceDespawnRange*forceDespawnRange {
+		return true
+	}
+	return closest > despawnRange*despawnRange && rand.Intn(despawnChance) == 0
+}
+
+// Sets undead mobs on fire in daylight and puts out mobs in water. Returns true if the fire killed the mob.
+func burnMob(c *chunk.Chunk, mob chunk.Mob, kind mobKind, x, y, z float64) bool {
+	blockX, blockY, blockZ := int32(math.Floor(x)), int32(math.Floor(y)), int32(math.Floor(z))
+	feet := loadedBlockAt(blockX, blockY, blockZ)
+	head := loadedBlockAt(blockX, int32(math.Floor(y+kind.height)), blockZ)
+
+	fire := mob.Fire()
+	wasBurning := fire > 0
+
+	switch {
+	case feet.IsWater() || head.IsWater():
+		fire = 0
+	case feet.IsLava() || head.IsLava():
+		fire = lavaBurnTime
+	case feet == block.Fire:
+		if fire < fireBurnTime {
+			fire = fireBurnTime
+		}
+	case kind.burnsInDaylight && isDaytime() && !storage.GetWeather().Raining && canSeeSky(blockX, blockY+1, blockZ):
+		if fire < daylightBurnTime {
+			fire = daylightBurnTime
+		}
+	}
+
+	dead := false
+	if fire > 0 {
+		fire--
+		if fire%20 == 0 {
+			dead = damageMob(c, mob, 1)
+		}
+	}
+	c.UpdateEntities(func() {
+		mob.SetFire(fire)
+	})
+
+	if (fire > 0) != wasBurning {
+		id := chunk.Entity(mob).ID()
+		sendToObservers(id, protocol.EntityMetadata{ID: id, Metadata: protocol.Metadata{protocol.MetaFlags: mob.Metadata()[protocol.MetaFlags]}})
+	}
+	return dead
+}
+
+// Reports whether a player can be chased and attacked.
+func isTarget(p *_player) bool {
+	select {
+	case <-p.quit:
+		return false // Disconnected
+	default:
+	}
+	return p.isSpawned() && !p.isDead() && !p.stored.Abilities.Invulnerable
+}
+
+func nearestTarget(x, y, z float64) *_player {
+	var nearest *_player
+	closest := float64(huntRange * huntRange)
+	for _, player := range registered.onlinePlayers() {
+		p := player.(*_player)
+		if !isTarget(p) {
+			continue
+		}
+		px, py, pz := p.Position()
+		if d := (px-x)*(px-x) + (py-y)*(py-y) + (pz-z)*(pz-z); d < closest {
+			nearest, closest = p, d
+		}
+	}
+	return nearest
+}
+
+// Chases and attacks the nearest player. Returns false for hunting if there is nobody to chase, and true for
+// removed if the mob blew itself up.
+func hunt(c *chunk.Chunk, mob chunk.Mob, kind mobKind, state *mobState, x, y, z, my float64) (nmx, nmy, nmz float64, yaw float32, hunting, removed bool) {
+	id := chunk.Entity(mob).ID()
+	yaw = mob.Yaw()
+
+	if state.target != nil {
+		tx, ty, tz := state.target.Position()
+		if !isTarget(state.target) || (tx-x)*(tx-x)+(ty-y)*(ty-y)+(tz-z)*(tz-z) > loseRange*loseRange {
+			state.target, state.path = nil, nil
+		}
+	}
+	if state.target == nil {
+		state.target = nearestTarget(x, y, z)
+	}
+	if state.target == nil {
+		if state.fuse > 0 {
+			state.fuse = 0
+			sendToObservers(id, protocol.EntityMetadata{ID: id, Metadata: protocol.Metadata{16: int8(-1)}})
+		}
+		return 0, my, 0, yaw, false, false
+	}
+
+	target := state.target
+	tx, ty, tz := target.Position()
+	distance := math.Sqrt((tx-x)*(tx-x) + (ty-y)*(ty-y) + (tz-z)*(tz-z))
+	yaw = faceDirection(tx-x, tz-z)
+	if state.cooldown > 0 {
+		state.cooldown--
+	}
+
+	switch kind.attack {
+	case attackMelee:
+		if distance < meleeReach && state.cooldown == 0 {
+			target.attackedBy(mob.Type(), x, z, scaleForDifficulty(kind.damage), damageAttack)
+			state.cooldown = meleeCooldown
+		}
+	case attackRanged:
+		if distance < rangedReach && canSee(x, y+kind.height*0.85, z, tx, ty+eyeHeight, tz) {
+			if state.cooldown == 0 {
+				target.attackedBy(mob.Type(), x, z, scaleForDifficulty(kind.damage), damageAttack)
+				state.cooldown = rangedCooldown
+			}
+			if distance < rangedReach/2 {
+				// Close enough. Stand still and shoot.
+				return 0, my, 0, yaw, true, false
+			}
+		}
+	case attackExplode:
+		if distance < fuseReach || (state.fuse > 0 && distance < fuseCancelReach) {
+			if state.fuse == 0 {
+				sendToObservers(id, protocol.EntityMetadata{ID: id, Metadata: protocol.Metadata{16: int8(1)}})
+			}
+			if state.fuse++; state.fuse >= fuseTicks {
+				removeMob(c, mob)
+				explode(x, y, z, creeperRadius, mob.Type())
+				return 0, my, 0, yaw, true, true
+			}
+			return 0, my, 0, yaw, true, false
+		}
+		if state.fuse > 0 {
+			state.fuse = 0
+			sendToObservers(id, protocol.EntityMetadata{ID: id, Metadata: protocol.Metadata{16: int8(-1)}})
+		}
+	}
+
+	if state.path == nil || config.CurrentTick() >= state.pathTick {
+		walker := pathfind.Walker{Height: int32(math.Ceil(kind.height)), MaxDrop: mobMaxDropDown, MaxNodes: 400}
+		start := pathfind.Point{X: int32(math.Floor(x)), Y: int32(math.Floor(y)), Z: int32(math.Floor(z))}
+		goal := pathfind.Point{X: int32(math.Floor(tx)), Y: int32(math.Floor(ty)), Z: int32(math.Floor(tz))}
+		state.path, _ = walker.Find(loadedBlockAt, start, goal)
+		state.pathTick = config.CurrentTick() + repathInterval
+	}
+
+	// Skip the parts of the path the mob has already walked.
+	for len(state.path) > 0 {
+		next := state.path[0]
+		if next.X != int32(math.Floor(x)) || next.Z != int32(math.Floor(z)) || math.Abs(float64(next.Y)-y) >= 1 {
+			break
+		}
+		state.path = state.path[1:]
+	}
+
+	// With no path, go straight for the target.
+	nextX, nextY, nextZ := tx, ty, tz
+	if len(state.path) > 0 {
+		next := state.path[0]
+		nextX, nextY, nextZ = float64(next.X)+0.5, float64(next.Y), float64(next.Z)+0.5
+	}
+	dx, dz := nextX-x, nextZ-z
+	if d := math.Sqrt(dx*dx + dz*dz); d > 0.01 {
+		dx, dz = dx/d, dz/d
+	} else {
+		dx, dz = 0, 0
+	}
+
+	if nextY > y+0.5 && chunk.Entity(mob).OnGround() {
+		my = mobJumpSpeed
+	} else {
+		my, _ = mobStep(mob, kind, x, y, z, dx, dz, my)
+	}
+	speed := kind.speed * huntSpeed
+	return dx * speed, my, dz * speed, yaw, true, false
+}
+
+// Reports whether there is nothing solid on the straight line between two points.
+func canSee(x1, y1, z1, x2, y2, z2 float64) bool {
+	dx, dy, dz := x2-x1, y2-y1, z2-z1
+	steps := int(math.Sqrt(dx*dx+dy*dy+dz*dz) * 4)
+	for i := 1; i < steps; i++ {
+		f := float64(i) / float64(steps)
+		if solidAt(x1+dx*f, y1+dy*f, z1+dz*f) {
+			return false
+		}
+	}
+	return true
+}
+
+// Plays an explosion and hurts the players near it, more the closer they are. No blocks are broken.
+func explode(x, y, z, radius float64, source string) {
+	SendToAllNearChunk(int32(math.Floor(x))>>4, int32(math.Floor(z))>>4, protocol.Explosion{X: x, Y: y, Z: z, Radius: float32(radius)})
+
+	reach := radius * 2
+	for _, player := range registered.onlinePlayers() {
+		p := player.(*_player)
+		if !p.isSpawned() {
+			continue
+		}
+		px, py, pz := p.Position()
+		distance := math.Sqrt((px-x)*(px-x) + (py-y)*(py-y) + (pz-z)*(pz-z))
+		if distance >= reach {
+			continue
+		}
+		impact := 1 - distance/reach
+		damage := int((impact*impact+impact)/2*8*radius + 1)
+		p.attackedBy(source, x, z, scaleForDifficulty(damage), damageExplosion)
+	}
+}
+
+func spawnMonsters() {
+	if config.Config.MonstersPerChunk <= 0 || peaceful() {
+		return
+	}
+
+	for _, c := range storage.LoadedChunks() {
+		if rand.Intn(monsterSpawnChance) == 0 {
+			spawnMonstersIn(c)
+		}
+		storage.ReleaseChunk(c.X, c.Z)
+	}
+}
+
+// Spawns a pack of one kind of monster somewhere dark in a chunk, without going over the chunk's limit.
+func spawnMonstersIn(c *chunk.Chunk) {
+	room := config.Config.MonstersPerChunk
+	for _, ent := range c.GetEntities() {
+		if mob := ent.Mob(); mob != nil && mobKinds[mob.Type()].hostile {
+			room--
+		}
+	}
+	if room <= 0 {
+		return
+	}
+
+	kind := monsters[rand.Intn(len(monsters))]
+	pack := 1 + rand.Intn(maxPackSize)
+	if pack > room {
+		pack = room
+	}
+
+	online := registered.onlinePlayers()
+	for i := 0; i < pack; i++ {
+		x, z := c.X<<4|int32(rand.Intn(16)), c.Z<<4|int32(rand.Intn(16))
+		y := 1 + int32(rand.Intn(int(c.GetHighestBlockYAt(x, z))+1))
+		if !canSpawnMonsterAt(c, x, y, z) || nearPlayer(online, float64(x), float64(z), monsterSpawnDistance) {
+			continue
+		}
+		mob := chunk.NewMob(kind, float64(x)+0.5, float64(y), float64(z)+0.5, rand.Float32()*360)
+		c.SpawnEntity(chunk.Entity(mob))
+		// The entity tracker will spawn it for nearby players on the next tick.
+	}
+}
+
+// Monsters need solid ground, two blocks of air and darkness.
+func canSpawnMonsterAt(c *chunk.Chunk, x, y, z int32) bool {
+	if ground := c.GetBlock(x, y-1, z); ground.Passable() || ground.SemiPassable() || ground == block.Bedrock {
+		return false
+	}
+	for dy := int32(0); dy < 2; dy++ {
+		if b := c.GetBlock(x, y+dy, z); !b.Passable() || b.IsWater() || b.IsLava() {
+			return false
+		}
+	}
+	return GetLightAt(x, y, z) <= uint8(rand.Intn(maxMonsterLight+1))
+}
+
+func init() {
+	scheduler.Every(monsterSpawnInterval, "monster spawning", spawnMonsters)
+}
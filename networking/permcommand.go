@@ -0,0 +1,133 @@
+package networking
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Nightgunner5/stuzzd/permissions"
+	"strings"
+)
+
+func init() {
+	RegisterCommand(&Command{
+		Name:        "perm",
+		Description: "View or change permissions. See /perm help.",
+		Permission:  "stuzzd.command.perm",
+		Args: []Arg{
+			{Name: "player|group|groups|help", Type: ArgWord},
+			{Name: "name", Type: ArgWord, Optional: true},
+			{Name: "action", Type: ArgWord, Optional: true},
+			{Name: "value", Type: ArgRest, Optional: true},
+		},
+		Run: commandPerm,
+	})
+}
+
+var permHelp = []string{
+	"/perm player <name> info",
+	"/perm player <name> has <node>",
+	"/perm player <name> addgroup|removegroup <group>",
+	"/perm player <name> set|unset <node>",
+	"/perm group <name> create [parent...]",
+	"/perm group <name> set|unset <node>",
+	"/perm group <name> format <color> [prefix...]",
+	"/perm groups",
+}
+
+func commandPerm(sender CommandSender, args Args) error {
+	switch args.String(0) {
+	case "help":
+		sendChat(sender, ChatInfo+"Nodes starting with - take a permission away. Use & for colors.")
+		for _, line := range permHelp {
+			sendChat(sender, ChatPayload+line)
+		}
+		return nil
+	case "groups":
+		sendChat(sender, ChatInfo+"Groups: "+ChatPayload+strings.Join(permissions.Groups(), ", "))
+		return nil
+	}
+
+	if !args.Has(1) || !args.Has(2) {
+		return UsageError("")
+	}
+	name, action := args.String(1), args.String(2)
+	value := ""
+	if args.Has(3) {
+		value = args.String(3)
+	}
+	needValue := func() error {
+		if value == "" {
+			return UsageError("That needs a value. See /perm help.")
+		}
+		return nil
+	}
+
+	var err error
+	var changed = true
+	switch args.String(0) + " " + action {
+	case "player info":
+		sendChat(sender, ChatInfo+"Groups of "+formatUsernameOffline(name)+": "+ChatPayload+strings.Join(permissions.GroupsOf(name), ", "))
+		return nil
+	case "player has":
+		if err = needValue(); err != nil {
+			return err
+		}
+		sendChat(sender, fmt.Sprintf("%s%s %shas %s: %s%v", ChatInfo, formatUsernameOffline(name), ChatInfo, value, ChatPayload, permissions.Has(name, value)))
+		return nil
+	case "player addgroup":
+		if err = needValue(); err != nil {
+			return err
+		}
+		changed, err = permissions.AddToGroup(name, value)
+	case "player removegroup":
+		if err = needValue(); err != nil {
+			return err
+		}
+		changed, err = permissions.RemoveFromGroup(name, value)
+	case "player set":
+		if err = needValue(); err != nil {
+			return err
+		}
+		err = permissions.SetPlayerPermission(name, value)
+	case "player unset":
+		if err = needValue(); err != nil {
+			return err
+		}
+		changed, err = permissions.UnsetPlayerPermission(name, value)
+	case "group create":
+		changed, err = permissions.CreateGroup(name, strings.Fields(value)...)
+	case "group set":
+		if err = needValue(); err != nil {
+			return err
+		}
+		err = permissions.SetGroupPermission(name, value)
+	case "group unset":
+		if err = needValue(); err != nil {
+			return err
+		}
+		changed, err = permissions.UnsetGroupPermission(name, value)
+	case "group format":
+		if err = needValue(); err != nil {
+			return err
+		}
+		format := strings.SplitN(strings.Replace(value, "&", "§", -1), " ", 2)
+		prefix := ""
+		if len(format) > 1 {
+			prefix = format[1] + " "
+		}
+		err = permissions.SetGroupFormat(name, prefix, format[0])
+	default:
+		return UsageError("Unknown action. See /perm help.")
+	}
+
+	if err == permissions.ErrNoSuchGroup {
+		return errors.New("There is no group with that name.")
+	}
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return errors.New("Nothing needed to change.")
+	}
+	sendChat(sender, ChatInfo+"Permissions updated.")
+	return nil
+}
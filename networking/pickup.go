@@ -0,0 +1,80 @@
+package networking
+
+import (
+	"github.com/Nightgunner5/stuzzd/chunk"
+	"github.com/Nightgunner5/stuzzd/player"
+	"github.com/Nightgunner5/stuzzd/protocol"
+	"github.com/Nightgunner5/stuzzd/storage"
+	"math"
+)
+
+// How far outside a player's bounding box items are picked up.
+const pickupRange = 1
+
+func pickupItems() {
+	for _, p := range registered.onlinePlayers() {
+		if p.(*_player).isSpawned() {
+			p.(*_player).pickupNearbyItems()
+		}
+	}
+}
+
+func (p *_player) pickupNearbyItems() {
+	x, y, z := p.Position()
+	chunkX, chunkZ := int32(math.Floor(x))>>4, int32(math.Floor(z))>>4
+
+	for cx := chunkX - 1; cx <= chunkX+1; cx++ {
+		for cz := chunkZ - 1; cz <= chunkZ+1; cz++ {
+			c := storage.GetChunk(cx, cz)
+			for _, ent := range c.GetEntities() {
+				drop := ent.ItemDrop()
+				if drop == nil {
+					continue
+				}
+
+				if drop.PickupDelay() > 0 {
+					continue
+				}
+
+				ix, iy, iz := ent.Position()
+				if math.Abs(ix-x) > 0.3+pickupRange || math.Abs(iz-z) > 0.3+pickupRange || iy < y-0.5 || iy > y+1.8 {
+					continue
+				}
+
+				p.pickup(c, drop)
+			}
+			storage.ReleaseChunk(cx, cz)
+		}
+	}
+}
+
+// Runs on the scheduler's goroutine, so the slot updates are queued rather than waiting for the client.
+func (p *_player) pickup(c *chunk.Chunk, drop chunk.ItemDrop) {
+	p.inventoryLock.Lock()
+	item := &player.InventoryItem{Type: drop.Item(), Damage: drop.Damage(), Count: drop.Count()}
+	changed := p.storeItem(item)
+	updates := make([]protocol.SetSlot, 0, len(changed))
+	for _, slot := range changed {
+		if pkt, ok := p.slotPacket(slot); ok {
+			updates = append(updates, pkt)
+		}
+	}
+	p.inventoryLock.Unlock()
+
+	if len(changed) == 0 {
+		return // Inventory is full.
+	}
+	for _, pkt := range updates {
+		p.queuePacket(pkt)
+	}
+
+	if item.Count > 0 {
+		c.UpdateEntities(func() {
+			drop.SetCount(item.Count)
+		})
+		return
+	}
+
+	sendToObservers(chunk.Entity(drop).ID(), protocol.CollectItem{Collected: chunk.Entity(drop).ID(), Collector: p.id})
+	despawnEntity(c, chunk.Entity(drop))
+}
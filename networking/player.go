@@ -12,18 +12,23 @@ import (
 	"math"
 	"math/rand"
 	"net"
-	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-var OnlinePlayerCount uint64
-
 func HandlePlayer(conn net.Conn) Player {
 	p := new(_player)
 	p.id = assignID()
+	p.remoteAddr = conn.RemoteAddr()
 	p.chunkSet = make(map[uint64]*chunk.Chunk)
+	p.tracked = make(map[int32]Entity)
 	p.sendq = make(chan protocol.Packet)
+	p.queueWake = make(chan struct{}, 1)
+	p.streamWake = make(chan struct{}, 1)
+	p.quit = make(chan struct{})
+	p.done = make(chan struct{})
 
 	go func() {
 		defer func() {
@@ -35,17 +40,26 @@ func HandlePlayer(conn net.Conn) Player {
 					safeSendPacket(p, conn, protocol.Kick{Reason: fmt.Sprint("Error: ", err)})
 				}
 			}
+			saveLock.RLock()
+			close(p.quit)
 			RemoveEntity(p)
-			if p.authenticated {
-				storage.SaveAndUnloadPlayer(p.Username(), p.stored)
-				OnlinePlayerCount--
+			if p.Authenticated() && !allSaved {
+				p.inventoryLock.Lock()
+				p.returnCursor()
+				p.inventoryLock.Unlock()
+				if err := p.save(storage.SaveAndUnloadPlayer); err != nil {
+					log.Print("Failed to save ", p.Username(), ": ", err)
+				}
 			}
+			saveLock.RUnlock()
+			close(p.done)
 			time.Sleep(1 * time.Second)
 			SendToAllExcept(p, protocol.PlayerListItem{Name: p.Username(), Online: false, Ping: 0})
 			conn.Close()
 		}()
 		recvq := make(chan protocol.Packet)
 		go recv(p, conn, recvq)
+		go p.sendQueued()
 		sendKeepAlive := time.Tick(time.Second * 50) // 1000 ticks
 		for {
 			select {
@@ -132,6 +146,10 @@ func recv(p Player, in io.Reader, recvq chan<- protocol.Packet) {
 			recvq <- protocol.ReadHandshake(in)
 		case 0x03:
 			recvq <- protocol.ReadChat(in)
+		case 0x07:
+			recvq <- protocol.ReadUseEntity(in)
+		case 0x09:
+			recvq <- protocol.ReadRespawn(in)
 		case 0x0A:
 			recvq <- protocol.ReadFlying(in)
 		case 0x0B:
@@ -143,9 +161,9 @@ func recv(p Player, in io.Reader, recvq chan<- protocol.Packet) {
 		case 0x0E:
 			recvq <- protocol.ReadPlayerDigging(in)
 		case 0x0F:
-			panic("NO CLICKING!")
+			recvq <- protocol.ReadPlayerBlockPlacement(in)
 		case 0x10:
-			in.Read(make([]byte, 2)) // TODO
+			recvq <- protocol.ReadHeldItemChange(in)
 		case 0x12:
 			recvq <- protocol.ReadAnimation(in)
 		case 0x13:
@@ -153,7 +171,13 @@ func recv(p Player, in io.Reader, recvq chan<- protocol.Packet) {
 		case 0x47: // When the server sends this, it's a lightning bolt. When the client sends it, it means they're doing a HTTP GET. Switch over to the HTTP handler.
 			recvq <- SwitchToHttp{}
 		case 0x65:
-			in.Read(make([]byte, 1)) // TODO
+			recvq <- protocol.ReadCloseWindow(in)
+		case 0x66:
+			recvq <- protocol.ReadWindowClick(in)
+		case 0x6A:
+			recvq <- protocol.ReadTransaction(in)
+		case 0x6B:
+			recvq <- protocol.ReadCreativeInventoryAction(in)
 		case 0xCA:
 			recvq <- protocol.ReadPlayerAbilities(in)
 		case 0xFE:
@@ -200,13 +224,79 @@ type _player struct {
 	stored        *player.Player
 	username      string
 	logintoken    uint64
-	authenticated bool
+	remoteAddr    net.Addr
+	authenticated int32 // Accessed atomically
 	sendq         chan protocol.Packet
+	queued        []protocol.Packet
+	queueLock     sync.Mutex // Guards queued
+	queueWake     chan struct{}
+	streamWake    chan struct{}
+	quit          chan struct{} // Closed when the connection ends
+	done          chan struct{} // Closed once the player has been saved after the connection ends
 	movecounter   uint8
 	lastMoveTick  uint64
 	gameMode      protocol.ServerMode
 	chunkSet      map[uint64]*chunk.Chunk
-	spawned       bool
+	chunkLock     sync.RWMutex // Guards chunkSet
+	tracked       map[int32]Entity
+	trackLock     sync.Mutex   // Guards tracked
+	spawned       int32        // Accessed atomically
+	positionLock  sync.RWMutex // Guards the stored position and rotation
+	inventoryLock sync.Mutex
+	heldSlot      int16
+	cursor        *player.InventoryItem
+	eatingItem    int16      // The food the player is eating, or 0. Guarded by inventoryLock like heldSlot.
+	eatingSince   uint64     // The tick they started eating
+	chatLock      sync.Mutex // Guards the stored StuzzD fields, socialSpy and the chat throttle
+	socialSpy     bool
+	chatSpam      uint64 // Goes up with each message and down with each tick
+	chatSpamTick  uint64
+	lastChat      string
+	lastChatTick  uint64
+	statsLock     sync.Mutex // Guards the stored health, hunger, air, fire and fall distance, and the fields below
+	lastHurt      int
+	lastHurtTick  uint64
+	sentHealth    *protocol.UpdateHealth
+	attacker      string // The formatted name of whoever last hit the player, for the death message
+}
+
+// Held for reading while a player who disconnected is saved and for writing by SaveAllPlayers, so the server
+// can't exit partway through writing a player's file.
+var saveLock sync.RWMutex
+
+// Set by SaveAllPlayers. Players who disconnect after it has run were saved by it.
+var allSaved bool
+
+// Saves every online player before the server exits. Players who disconnect while it runs are saved either by
+// it or before it, never after. Returns an error for each player that could not be saved.
+func SaveAllPlayers() []error {
+	saveLock.Lock()
+	defer saveLock.Unlock()
+
+	allSaved = true
+	var errs []error
+	for _, player := range registered.onlinePlayers() {
+		p := player.(*_player)
+		if err := p.save(storage.SavePlayer); err != nil {
+			errs = append(errs, fmt.Errorf("player %s: %v", p.Username(), err))
+		}
+	}
+	return errs
+}
+
+// Writes the player's stored data with write. Packets and ticks that are still running can change it, so it is
+// written under every lock that guards it.
+func (p *_player) save(write func(name string, stored *player.Player) error) error {
+	p.inventoryLock.Lock()
+	defer p.inventoryLock.Unlock()
+	p.chatLock.Lock()
+	defer p.chatLock.Unlock()
+	p.statsLock.Lock()
+	defer p.statsLock.Unlock()
+	p.positionLock.Lock()
+	defer p.positionLock.Unlock()
+
+	return write(p.Username(), p.stored)
 }
 
 func (p *_player) ID() int32 {
@@ -227,21 +317,78 @@ func (p *_player) getLoginToken() uint64 {
 }
 
 func (p *_player) SendPacketSync(packet protocol.Packet) {
-	p.sendq <- packet
+	select {
+	case p.sendq <- packet:
+	case <-p.quit:
+		// Nobody is left to send it.
+	}
+}
+
+// Adds a packet to the end of the player's ordered queue without waiting for it to be sent. Packets in the
+// queue go out in the order they were added, so an entity's spawn is never overtaken by its movement or its
+// destruction like it can be when each packet is sent from its own goroutine.
+func (p *_player) queuePacket(packet protocol.Packet) {
+	p.queueLock.Lock()
+	p.queued = append(p.queued, packet)
+	p.queueLock.Unlock()
+
+	select {
+	case p.queueWake <- struct{}{}:
+	default:
+		// Already woken.
+	}
+}
+
+// Moves queued packets to the send queue until the connection ends.
+func (p *_player) sendQueued() {
+	for {
+		select {
+		case <-p.queueWake:
+		case <-p.quit:
+			return
+		}
+
+		p.queueLock.Lock()
+		queued := p.queued
+		p.queued = nil
+		p.queueLock.Unlock()
+
+		for _, packet := range queued {
+			p.SendPacketSync(packet)
+		}
+	}
+}
+
+// Reports whether the player's entity exists on their client, so they can move and be seen.
+func (p *_player) isSpawned() bool {
+	return atomic.LoadInt32(&p.spawned) != 0
+}
+
+func (p *_player) setSpawned(spawned bool) {
+	var value int32
+	if spawned {
+		value = 1
+	}
+	atomic.StoreInt32(&p.spawned, value)
 }
 
 func (p *_player) Authenticated() bool {
-	return p.authenticated
+	return atomic.LoadInt32(&p.authenticated) != 0
+}
+
+// Marks the player as logged in. This must be done after the stored player data has been loaded.
+func (p *_player) setAuthenticated() {
+	atomic.StoreInt32(&p.authenticated, 1)
 }
 
 func (p *_player) SendPosition(x, y, z float64) {
-	if !p.spawned {
+	if !p.isSpawned() {
 		return
 	}
-	if p.lastMoveTick == config.Tick {
+	if p.lastMoveTick == config.CurrentTick() {
 		return
 	}
-	p.lastMoveTick = config.Tick
+	p.lastMoveTick = config.CurrentTick()
 	defer func() {
 		if recover() != nil {
 			p.ForcePosition()
@@ -250,7 +397,8 @@ func (p *_player) SendPosition(x, y, z float64) {
 
 	px, py, pz := p.Position()
 
-	if y < 0 {
+	// Players who can't be hurt would fall forever, so they get put back. Everyone else takes void damage.
+	if y < 0 && p.stored.Abilities.Invulnerable {
 		if py < 1 {
 			p.SetPosition(px, 128, pz)
 		}
@@ -276,7 +424,7 @@ func (p *_player) SendPosition(x, y, z float64) {
 
 	p.movecounter++
 	if p.movecounter < 10 && distance < 4 {
-		SendToAllExcept(p, protocol.EntityRelativeMove{
+		sendToObservers(p.id, protocol.EntityRelativeMove{
 			ID: p.id,
 			X:  protocol.CheckedFloatToByte(x - px),
 			Y:  protocol.CheckedFloatToByte(y - py),
@@ -284,7 +432,7 @@ func (p *_player) SendPosition(x, y, z float64) {
 		})
 	} else {
 		yaw, pitch := p.Angles()
-		SendToAllExcept(p, protocol.EntityTeleport{
+		sendToObservers(p.id, protocol.EntityTeleport{
 			ID:    p.id,
 			X:     x,
 			Y:     y,
@@ -299,7 +447,7 @@ func (p *_player) SendPosition(x, y, z float64) {
 func (p *_player) ForcePosition() {
 	x, y, z := p.Position()
 	yaw, pitch := p.Angles()
-	SendToAllExcept(p, protocol.EntityTeleport{
+	sendToObservers(p.id, protocol.EntityTeleport{
 		ID:    p.id,
 		X:     x,
 		Y:     y,
@@ -319,68 +467,74 @@ func (p *_player) ForcePosition() {
 }
 
 func (p *_player) sendWorldData() {
-	go func() {
-		for {
-			for i, chunk := range p.chunkSet {
-				x, z := chunk.X, chunk.Z
-				dx, dz := (int32(p.stored.Position[0])>>4)-x, (int32(p.stored.Position[2])>>4)-z
-				if dx > 10 || dx < -10 || dz > 10 || dz < -10 {
-					storage.ReleaseChunk(x, z)
-					sendChunk(p, x, z, nil)
-					delete(p.chunkSet, i)
-				}
-			}
+	go p.streamChunks()
+}
 
-			for i := int32(1); i <= 8; i++ {
-				middleX, middleZ := int32(p.stored.Position[0]/16), int32(p.stored.Position[2]/16)
-				for x := middleX - i; x < middleX+i; x++ {
-					for z := middleZ - i; z < middleZ+i; z++ {
-						id := uint64(uint32(x))<<32 | uint64(uint32(z))
-						if _, ok := p.chunkSet[id]; !ok {
-							p.chunkSet[id] = storage.GetChunk(x, z)
-							sendChunk(p, x, z, p.chunkSet[id])
-							runtime.Gosched()
-						}
-					}
-				}
+func (p *_player) hasChunk(x, z int32) bool {
+	return p.loadedChunk(x, z) != nil
+}
 
-				if i == 2 && !p.spawned {
-					p.sendSpawnPacket()
-					p.spawned = true
-				}
-			}
-			time.Sleep(100 * time.Millisecond)
-		}
-	}()
+// Returns the chunk if it has been sent to the player, or nil otherwise.
+func (p *_player) loadedChunk(x, z int32) *chunk.Chunk {
+	p.chunkLock.RLock()
+	defer p.chunkLock.RUnlock()
+
+	return p.chunkSet[uint64(uint32(x))<<32|uint64(uint32(z))]
 }
 
 func (p *_player) SetPosition(x, y, z float64) {
+	p.positionLock.Lock()
+	defer p.positionLock.Unlock()
+
 	p.stored.Position[0], p.stored.Position[1], p.stored.Position[2] = x, y, z
 }
 
 func (p *_player) Position() (x, y, z float64) {
+	p.positionLock.RLock()
+	defer p.positionLock.RUnlock()
+
 	return p.stored.Position[0], p.stored.Position[1], p.stored.Position[2]
 }
 
 func (p *_player) SendAngles(yaw, pitch float32) {
 	p.SetAngles(yaw, pitch)
-	SendToAllExcept(p, protocol.EntityLook{ID: p.id, Yaw: yaw, Pitch: pitch})
-	SendToAllExcept(p, protocol.EntityHeadLook{ID: p.id, Yaw: yaw})
+	sendToObservers(p.id, protocol.EntityLook{ID: p.id, Yaw: yaw, Pitch: pitch})
+	sendToObservers(p.id, protocol.EntityHeadLook{ID: p.id, Yaw: yaw})
 }
 
 func (p *_player) SetAngles(yaw, pitch float32) {
+	p.positionLock.Lock()
+	defer p.positionLock.Unlock()
+
 	p.stored.Rotation[0], p.stored.Rotation[1] = yaw, pitch
 }
 
 func (p *_player) Angles() (yaw, pitch float32) {
+	p.positionLock.RLock()
+	defer p.positionLock.RUnlock()
+
 	return p.stored.Rotation[0], p.stored.Rotation[1]
 }
 
+// The game mode a player is put in when they join.
+func (p *_player) joinGameMode() protocol.ServerMode {
+	if config.Config.ForceGameMode {
+		return protocol.ServerMode(config.Config.DefaultGameMode)
+	}
+	if p.stored.Abilities.InstaBuild {
+		// Saved before the game mode was.
+		return protocol.Creative
+	}
+	return protocol.ServerMode(p.stored.GameType)
+}
+
 func (p *_player) SetGameMode(mode protocol.ServerMode) {
 	p.gameMode = mode
+	p.stored.GameType = uint32(mode)
 	p.stored.Abilities.InstaBuild = mode == protocol.Creative
 	p.stored.Abilities.Invulnerable = mode == protocol.Creative
 	p.stored.Abilities.MayFly = mode == protocol.Creative
+	p.stored.Abilities.MayBuild = mode != protocol.Adventure
 	if !p.stored.Abilities.MayFly {
 		p.stored.Abilities.Flying = false
 	}
@@ -388,24 +542,35 @@ func (p *_player) SetGameMode(mode protocol.ServerMode) {
 	p.SendPacketSync(protocol.ChangeGameState{Type: protocol.ChangeGameMode, Mode: mode})
 }
 
-func (p *_player) SpawnPacket(w io.Writer) {
+func (p *_player) SpawnPacket(w io.Writer) bool {
 	x, y, z := p.Position()
 	yaw, pitch := p.Angles()
+
+	p.inventoryLock.Lock()
+	var inHand int16
+	if held := p.heldItem(); held != nil {
+		inHand = held.Type
+	}
+	p.inventoryLock.Unlock()
+
 	w.Write(protocol.SpawnNamedEntity{
-		EID:   p.id,
-		Name:  p.username,
-		X:     x,
-		Y:     y,
-		Z:     z,
-		Yaw:   yaw,
-		Pitch: pitch,
+		EID:        p.id,
+		Name:       p.username,
+		X:          x,
+		Y:          y,
+		Z:          z,
+		Yaw:        yaw,
+		Pitch:      pitch,
+		ItemInHand: inHand,
 	}.Packet())
+	return true
 }
 
+// Queued so it can't overtake a Respawn packet.
 func (p *_player) sendSpawnPacket() {
 	x, y, z := p.Position()
 	yaw, pitch := p.Angles()
-	p.SendPacketSync(protocol.PlayerPositionLook{
+	p.queuePacket(protocol.PlayerPositionLook{
 		X:      x,
 		Y1:     y + 2,
 		Y2:     y + 3,
@@ -427,9 +592,6 @@ func sendPacket(p Player, conn net.Conn, packet protocol.Packet) {
 				SendToAll(protocol.Chat{Message: fmt.Sprintf("%s was kicked: %s", formatUsername(p), kick.Reason)})
 			}
 		}
-		for _, chunk := range p.(*_player).chunkSet {
-			storage.ReleaseChunk(chunk.X, chunk.Z)
-		}
 	}
 	if _, err := conn.Write(packet.Packet()); err != nil {
 		panic(err)
@@ -444,30 +606,24 @@ func safeSendPacket(p Player, conn net.Conn, packet protocol.Packet) {
 
 func SendToAll(packet protocol.Packet) {
 	baked := protocol.BakePacket(packet)
-	for _, player := range players {
-		if player.Authenticated() {
-			go player.SendPacketSync(baked)
-		}
+	for _, player := range registered.onlinePlayers() {
+		go player.SendPacketSync(baked)
 	}
 }
 
 func SendToAllExcept(exclude Player, packet protocol.Packet) {
 	baked := protocol.BakePacket(packet)
-	for _, player := range players {
-		if player.ID() == exclude.ID() {
-			continue
-		}
-		if player.Authenticated() {
+	for _, player := range registered.onlinePlayers() {
+		if player.ID() != exclude.ID() {
 			go player.SendPacketSync(baked)
 		}
 	}
 }
 
 func SendToAllNearChunk(chunkX, chunkZ int32, packet protocol.Packet) {
-	id := uint64(uint32(chunkX))<<32 | uint64(uint32(chunkZ))
 	baked := protocol.BakePacket(packet)
-	for _, player := range players {
-		if _, ok := player.(*_player).chunkSet[id]; ok {
+	for _, player := range registered.allPlayers() {
+		if player.(*_player).hasChunk(chunkX, chunkZ) {
 			go player.SendPacketSync(baked)
 		}
 	}
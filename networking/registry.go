@@ -0,0 +1,120 @@
+package networking
+
+import (
+	"strings"
+	"sync"
+)
+
+// Keeps track of connected players and other entities that don't live in a chunk.
+// All methods are safe to call from multiple goroutines.
+type registry struct {
+	lock     sync.RWMutex
+	entities map[int32]Entity
+	players  map[int32]Player
+	slots    map[int32]bool // Players that have claimed one of the server's slots
+}
+
+func newRegistry() *registry {
+	return &registry{
+		entities: make(map[int32]Entity),
+		players:  make(map[int32]Player),
+		slots:    make(map[int32]bool),
+	}
+}
+
+var registered = newRegistry()
+
+func (r *registry) add(ent Entity) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.entities[ent.ID()] = ent
+	if p, ok := ent.(Player); ok {
+		r.players[ent.ID()] = p
+	}
+}
+
+// Removes an entity, freeing its slot if it is a player. Returns false if it was not registered.
+func (r *registry) remove(ent Entity) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.entities[ent.ID()]; !ok {
+		return false
+	}
+	delete(r.entities, ent.ID())
+	delete(r.players, ent.ID())
+	delete(r.slots, ent.ID())
+	return true
+}
+
+func (r *registry) get(id int32) Entity {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.entities[id]
+}
+
+// Returns a snapshot of every connected player, including those who have not finished logging in.
+func (r *registry) allPlayers() []Player {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	players := make([]Player, 0, len(r.players))
+	for _, p := range r.players {
+		players = append(players, p)
+	}
+	return players
+}
+
+// Returns a snapshot of the players who have finished logging in.
+func (r *registry) onlinePlayers() []Player {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	players := make([]Player, 0, len(r.players))
+	for _, p := range r.players {
+		if p.Authenticated() {
+			players = append(players, p)
+		}
+	}
+	return players
+}
+
+// Finds an online player by name, ignoring case. Returns nil if nobody by that name is online.
+func (r *registry) findPlayer(name string) Player {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	for _, p := range r.players {
+		if p.Authenticated() && strings.EqualFold(p.Username(), name) {
+			return p
+		}
+	}
+	return nil
+}
+
+// Claims one of the server's slots for a player. Returns false if all of them are taken.
+func (r *registry) claimSlot(p Player, slots uint64) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.players[p.ID()]; !ok {
+		return false
+	}
+	if r.slots[p.ID()] {
+		return true
+	}
+	if uint64(len(r.slots)) >= slots {
+		return false
+	}
+	r.slots[p.ID()] = true
+	return true
+}
+
+func (r *registry) onlineCount() uint64 {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return uint64(len(r.slots))
+}
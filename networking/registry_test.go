@@ -0,0 +1,164 @@
+package networking
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// Makes a player that isn't connected to anything. The registry only needs its ID, name and login state.
+func testPlayer(name string, authenticated bool) *_player {
+	p := &_player{id: assignID(), quit: make(chan struct{}), queueWake: make(chan struct{}, 1)}
+	p.setUsername(name)
+	if authenticated {
+		p.setAuthenticated()
+	}
+	return p
+}
+
+func TestRegistryFindPlayer(t *testing.T) {
+	r := newRegistry()
+	alice := testPlayer("Alice", true)
+	bob := testPlayer("Bob", false)
+	r.add(alice)
+	r.add(bob)
+
+	if p := r.findPlayer("aLiCe"); p != Player(alice) {
+		t.Errorf("findPlayer(aLiCe) = %v, want Alice", p)
+	}
+	if p := r.findPlayer("Bob"); p != nil {
+		t.Errorf("findPlayer(Bob) = %v, want nil for a player who hasn't logged in", p)
+	}
+	if p := r.findPlayer("Carol"); p != nil {
+		t.Errorf("findPlayer(Carol) = %v, want nil", p)
+	}
+
+	if n := len(r.allPlayers()); n != 2 {
+		t.Errorf("allPlayers has %d players, want 2", n)
+	}
+	if online := r.onlinePlayers(); len(online) != 1 || online[0] != Player(alice) {
+		t.Errorf("onlinePlayers = %v, want only Alice", online)
+	}
+}
+
+func TestRegistrySlots(t *testing.T) {
+	r := newRegistry()
+	players := []*_player{testPlayer("a", true), testPlayer("b", true), testPlayer("c", true)}
+	for _, p := range players {
+		r.add(p)
+	}
+
+	if !r.claimSlot(players[0], 2) || !r.claimSlot(players[1], 2) {
+		t.Fatal("claimSlot failed with slots free")
+	}
+	if !r.claimSlot(players[0], 2) {
+		t.Error("claimSlot failed for a player who already has a slot")
+	}
+	if r.claimSlot(players[2], 2) {
+		t.Error("claimSlot succeeded with every slot taken")
+	}
+	if n := r.onlineCount(); n != 2 {
+		t.Errorf("onlineCount = %d, want 2", n)
+	}
+
+	if !r.remove(players[0]) {
+		t.Error("remove returned false for a registered player")
+	}
+	if r.remove(players[0]) {
+		t.Error("remove returned true for a player that was already removed")
+	}
+	if n := r.onlineCount(); n != 1 {
+		t.Errorf("onlineCount = %d after a removal, want 1", n)
+	}
+	if !r.claimSlot(players[2], 2) {
+		t.Error("claimSlot failed after a slot was freed")
+	}
+	if r.claimSlot(players[0], 2) {
+		t.Error("claimSlot succeeded for a player that isn't registered")
+	}
+}
+
+func TestRegistrySnapshot(t *testing.T) {
+	r := newRegistry()
+	p := testPlayer("snapshot", true)
+	r.add(p)
+
+	snapshot := r.onlinePlayers()
+	r.remove(p)
+	r.add(testPlayer("other", true))
+
+	if len(snapshot) != 1 || snapshot[0] != Player(p) {
+		t.Errorf("snapshot changed after the registry did: %v", snapshot)
+	}
+}
+
+// Run with -race. Players join, look each other up, broadcast and leave all at once.
+func TestRegistryConcurrent(t *testing.T) {
+	const workers, rounds = 16, 100
+
+	r := newRegistry()
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				name := fmt.Sprintf("player%d_%d", w, i)
+				p := testPlayer(name, false)
+				r.add(p)
+				if !r.claimSlot(p, workers) {
+					t.Errorf("claimSlot failed for %s", name)
+				}
+				p.setAuthenticated()
+
+				if found := r.findPlayer(name); found != Player(p) {
+					t.Errorf("findPlayer(%s) = %v", name, found)
+				}
+				for _, other := range r.onlinePlayers() {
+					other.Username()
+				}
+				if n := r.onlineCount(); n == 0 || n > workers {
+					t.Errorf("onlineCount = %d with %s online", n, name)
+				}
+				r.get(p.ID())
+
+				if !r.remove(p) {
+					t.Errorf("remove failed for %s", name)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if n := len(r.allPlayers()); n != 0 {
+		t.Errorf("%d players left after everyone left", n)
+	}
+	if n := r.onlineCount(); n != 0 {
+		t.Errorf("onlineCount = %d after everyone left", n)
+	}
+}
+
+// The exported functions use the server's registry and also untrack removed entities.
+func TestRegisterEntityConcurrent(t *testing.T) {
+	const workers = 16
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			name := fmt.Sprintf("exported%d", w)
+			p := testPlayer(name, true)
+			RegisterEntity(p)
+			if FindPlayer(name) != Player(p) {
+				t.Errorf("FindPlayer(%s) didn't find them", name)
+			}
+			OnlinePlayerCount()
+			RemoveEntity(p)
+			if FindPlayer(name) != nil {
+				t.Errorf("FindPlayer(%s) found them after RemoveEntity", name)
+			}
+		}(w)
+	}
+	wg.Wait()
+}
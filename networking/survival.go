@@ -0,0 +1,277 @@
+package networking
+
+import (
+	"github.com/Nightgunner5/stuzzd/block"
+	"github.com/Nightgunner5/stuzzd/config"
+	"github.com/Nightgunner5/stuzzd/player"
+	"github.com/Nightgunner5/stuzzd/protocol"
+	"github.com/Nightgunner5/stuzzd/scheduler"
+	"math"
+)
+
+// Health, hunger, air and fire work like they do in vanilla survival. Players who are invulnerable (creative
+// mode) don't take damage or get hungry.
+
+type damageCause uint8
+
+const (
+	damageFall damageCause = iota
+	damageDrowning
+	damageSuffocation
+	damageFire
+	damageBurning
+	damageLava
+	damageVoid
+	damageStarvation
+	damageAttack
+	damageExplosion
+)
+
+const (
+	eyeHeight = 1.62
+
+	// Falls shorter than this many blocks don't hurt.
+	safeFallDistance = 3
+
+	// After taking damage, a player only takes more during this many ticks if it is more than the last hit.
+	hurtCooldown = 10
+
+	// How many ticks a player keeps burning after leaving fire or lava.
+	fireBurnTime = 160
+	lavaBurnTime = 300
+
+	// How often food heals or starvation hurts, in ticks.
+	foodTickInterval = 80
+	// Players heal when they have at least this much food.
+	foodRegenLevel = 18
+
+	// Every time a player's exhaustion goes over this, they lose a point of saturation or food.
+	exhaustionLimit = 4
+
+	exhaustionWalk   = 0.01 // Per block
+	exhaustionSwim   = 0.015
+	exhaustionJump   = 0.2
+	exhaustionDamage = 0.3
+	exhaustionRegen  = 3
+)
+
+func tickSurvival() {
+	for _, p := range registered.onlinePlayers() {
+		p.(*_player).tickSurvival()
+	}
+}
+
+func (p *_player) tickSurvival() {
+	if !p.isSpawned() {
+		return
+	}
+	p.tickEating()
+
+	x, y, z := p.Position()
+	blockX, blockZ := int32(math.Floor(x)), int32(math.Floor(z))
+	feet := GetBlockAt(blockX, int32(math.Floor(y)), blockZ)
+	head := GetBlockAt(blockX, int32(math.Floor(y+eyeHeight)), blockZ)
+
+	p.statsLock.Lock()
+	defer p.statsLock.Unlock()
+
+	stored := p.stored
+	if stored.Health == 0 {
+		// They may have died from something outside this function, like a fall.
+		p.sendHealth()
+		return
+	}
+	if stored.Abilities.Invulnerable {
+		stored.Air, stored.Fire, stored.FallDistance = player.MaxAir, 0, 0
+		p.sendHealth()
+		return
+	}
+
+	if head.IsWater() {
+		if stored.Air > 0 {
+			stored.Air--
+		} else if config.CurrentTick()%20 == 0 {
+			p.hurt(2, damageDrowning)
+		}
+	} else {
+		stored.Air = player.MaxAir
+	}
+
+	if head.Opaque() {
+		p.hurt(1, damageSuffocation)
+	}
+
+	switch {
+	case feet.IsWater() || head.IsWater():
+		stored.Fire = 0
+	case feet.IsLava() || head.IsLava():
+		p.hurt(4, damageLava)
+		stored.Fire = lavaBurnTime
+	case feet == block.Fire || head == block.Fire:
+		p.hurt(1, damageFire)
+		if stored.Fire < fireBurnTime {
+			stored.Fire = fireBurnTime
+		}
+	}
+	if stored.Fire > 0 {
+		stored.Fire--
+		if stored.Fire%20 == 0 {
+			p.hurt(1, damageBurning)
+		}
+	}
+
+	// Players take void damage below the same height that removes other entities.
+	if y < voidDepth {
+		p.hurt(4, damageVoid)
+	}
+
+	if stored.Health > 0 {
+		p.tickFood()
+	}
+	p.sendHealth()
+}
+
+// The caller must hold the player's stats lock.
+func (p *_player) tickFood() {
+	stored := p.stored
+
+	if stored.FoodExhaustionLevel > exhaustionLimit {
+		stored.FoodExhaustionLevel -= exhaustionLimit
+		if stored.FoodSaturationLevel > 0 {
+			stored.FoodSaturationLevel = float32(math.Max(float64(stored.FoodSaturationLevel-1), 0))
+		} else if stored.FoodLevel > 0 {
+			stored.FoodLevel--
+		}
+	}
+
+	switch {
+	case stored.FoodLevel >= foodRegenLevel:
+		if stored.FoodTickTimer++; stored.FoodTickTimer >= foodTickInterval {
+			stored.FoodTickTimer = 0
+			if stored.Health < player.MaxHealth {
+				stored.Health++
+				stored.FoodExhaustionLevel += exhaustionRegen
+			}
+		}
+	case stored.FoodLevel == 0:
+		if stored.FoodTickTimer++; stored.FoodTickTimer >= foodTickInterval {
+			stored.FoodTickTimer = 0
+			// Starving stops at five hearts on easy and half a heart on normal. On hard, it kills.
+			switch protocol.Difficulty(config.CurrentDifficulty()) {
+			case protocol.Easy:
+				if stored.Health > 10 {
+					p.hurt(1, damageStarvation)
+				}
+			case protocol.Normal:
+				if stored.Health > 1 {
+					p.hurt(1, damageStarvation)
+				}
+			case protocol.Hard:
+				p.hurt(1, damageStarvation)
+			}
+		}
+	default:
+		stored.FoodTickTimer = 0
+	}
+}
+
+// Moves the player like SendPosition and then works out falling and hunger from how far they went.
+func (p *_player) move(x, y, z float64, onGround bool) {
+	if !p.isSpawned() {
+		return
+	}
+	fromX, fromY, fromZ := p.Position()
+	p.SendPosition(x, y, z)
+	p.moved(fromX, fromY, fromZ, onGround)
+}
+
+// Updates the fall distance and exhaustion after the player moved from fromX, fromY, fromZ to where they are now.
+func (p *_player) moved(fromX, fromY, fromZ float64, onGround bool) {
+	if !p.isSpawned() {
+		return
+	}
+	x, y, z := p.Position()
+	feet := GetBlockAt(int32(math.Floor(x)), int32(math.Floor(y)), int32(math.Floor(z)))
+
+	p.statsLock.Lock()
+	defer p.statsLock.Unlock()
+
+	stored := p.stored
+	if stored.Health == 0 {
+		return
+	}
+	wasOnGround := stored.OnGround
+	stored.OnGround = onGround
+
+	if stored.Abilities.Flying || feet.IsWater() || feet.IsLava() || feet == block.Ladder || feet == block.Vines {
+		stored.FallDistance = 0
+	} else if y < fromY {
+		stored.FallDistance += float32(fromY - y)
+	}
+
+	if !stored.Abilities.Flying {
+		walked := math.Sqrt((x-fromX)*(x-fromX) + (z-fromZ)*(z-fromZ))
+		if feet.IsWater() {
+			stored.FoodExhaustionLevel += float32(walked * exhaustionSwim)
+		} else {
+			stored.FoodExhaustionLevel += float32(walked * exhaustionWalk)
+		}
+		if wasOnGround && !onGround && y > fromY {
+			stored.FoodExhaustionLevel += exhaustionJump
+		}
+	}
+
+	if onGround {
+		if stored.FallDistance > safeFallDistance {
+			p.hurt(int(math.Ceil(float64(stored.FallDistance-safeFallDistance))), damageFall)
+		}
+		stored.FallDistance = 0
+	}
+}
+
+// Damages the player, taking into account invulnerability and the cooldown after the last hit.
+// The caller must hold the player's stats lock.
+func (p *_player) hurt(amount int, cause damageCause) {
+	stored := p.stored
+	if stored.Abilities.Invulnerable || stored.Health == 0 || amount <= 0 {
+		return
+	}
+
+	if config.CurrentTick()-p.lastHurtTick < hurtCooldown {
+		if amount <= p.lastHurt {
+			return
+		}
+		amount, p.lastHurt = amount-p.lastHurt, amount
+	} else {
+		p.lastHurt, p.lastHurtTick = amount, config.CurrentTick()
+	}
+
+	stored.FoodExhaustionLevel += exhaustionDamage
+	if int(stored.Health) > amount {
+		stored.Health -= uint16(amount)
+		sendToObservers(p.id, protocol.EntityStatus{ID: p.id, Status: protocol.EntityHurt})
+	} else {
+		stored.Health = 0
+		sendToObservers(p.id, protocol.EntityStatus{ID: p.id, Status: protocol.EntityDead})
+		go p.die(cause, p.attacker)
+	}
+}
+
+// Sends the player's health and hunger if they have changed since they were last sent.
+// The caller must hold the player's stats lock.
+func (p *_player) sendHealth() {
+	health := protocol.UpdateHealth{
+		Health:     int16(p.stored.Health),
+		Food:       int16(p.stored.FoodLevel),
+		Saturation: p.stored.FoodSaturationLevel,
+	}
+	if p.sentHealth != nil && *p.sentHealth == health {
+		return
+	}
+	p.sentHealth = &health
+	p.queuePacket(health)
+}
+
+func init() {
+	scheduler.EveryTick("survival", tickSurvival)
+}
@@ -0,0 +1,235 @@
+package networking
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Nightgunner5/stuzzd/storage"
+	"math"
+	"strings"
+)
+
+// Moves the player, making sure the client has the destination chunk first so they don't fall through the world.
+func (p *_player) teleport(x, y, z float64, yaw, pitch float32) {
+	p.sendChunkIfNeeded(int32(math.Floor(x))>>4, int32(math.Floor(z))>>4)
+
+	p.statsLock.Lock()
+	p.stored.FallDistance = 0
+	p.statsLock.Unlock()
+
+	p.SetPosition(x, y, z)
+	p.SetAngles(yaw, pitch)
+	p.ForcePosition()
+}
+
+func (p *_player) teleportTo(target Player) {
+	x, y, z := target.Position()
+	yaw, pitch := target.Angles()
+	p.teleport(x, y, z, yaw, pitch)
+}
+
+func spawnPoint() (x, y, z float64) {
+	sx, sy, sz := storage.Spawn()
+	return float64(sx) + 0.5, float64(sy), float64(sz) + 0.5
+}
+
+func init() {
+	RegisterCommand(&Command{
+		Name:        "tp",
+		Aliases:     []string{"tpt"},
+		Description: "Teleport to a player or to x y z. Put a player's name first to teleport them instead.",
+		Permission:  "stuzzd.command.tp",
+		Args:        []Arg{{Name: "destination", Type: ArgRest}},
+		Run:         commandTeleport,
+	})
+	RegisterCommand(&Command{
+		Name:        "tphere",
+		Description: "Teleport a player to you.",
+		Permission:  "stuzzd.command.tphere",
+		Args:        []Arg{{Name: "player", Type: ArgPlayer}},
+		Run: func(sender CommandSender, args Args) error {
+			self, ok := sender.(*_player)
+			if !ok {
+				return ErrNotPlayer
+			}
+
+			target := args.Player(0).(*_player)
+			target.teleportTo(self)
+			sendChat(target, formatUsername(sender)+" teleported you to them.")
+			return nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:        "spawn",
+		Description: "Teleport to the world spawn.",
+		Run: func(sender CommandSender, args Args) error {
+			self, ok := sender.(*_player)
+			if !ok {
+				return ErrNotPlayer
+			}
+
+			x, y, z := spawnPoint()
+			yaw, pitch := self.Angles()
+			self.teleport(x, y, z, yaw, pitch)
+			return nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:        "setspawn",
+		Description: "Move the world spawn to where you are standing.",
+		Permission:  "stuzzd.command.setspawn",
+		Run: func(sender CommandSender, args Args) error {
+			self, ok := sender.(*_player)
+			if !ok {
+				return ErrNotPlayer
+			}
+
+			x, y, z := self.Position()
+			if err := storage.SetSpawn(int32(math.Floor(x)), int32(math.Floor(y)), int32(math.Floor(z))); err != nil {
+				return fmt.Errorf("The spawn could not be saved: %v", err)
+			}
+			sendChat(sender, ChatInfo+"The world spawn has been moved.")
+			return nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:        "sethome",
+		Description: "Set where /home takes you.",
+		Run: func(sender CommandSender, args Args) error {
+			self, ok := sender.(*_player)
+			if !ok {
+				return ErrNotPlayer
+			}
+
+			x, y, z := self.Position()
+			yaw, pitch := self.Angles()
+			self.chatLock.Lock()
+			self.stored.StuzzDHome = []float64{x, y, z, float64(yaw), float64(pitch)}
+			self.chatLock.Unlock()
+			sendChat(sender, ChatInfo+"Your home has been set.")
+			return nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:        "home",
+		Description: "Teleport to the place you set with /sethome.",
+		Run: func(sender CommandSender, args Args) error {
+			self, ok := sender.(*_player)
+			if !ok {
+				return ErrNotPlayer
+			}
+
+			self.chatLock.Lock()
+			home := self.stored.StuzzDHome
+			self.chatLock.Unlock()
+			if len(home) != 5 {
+				return errors.New("You haven't set a home. Use /sethome first.")
+			}
+
+			self.teleport(home[0], home[1], home[2], float32(home[3]), float32(home[4]))
+			return nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:        "warp",
+		Description: "Teleport to a warp, or list the warps.",
+		Args:        []Arg{{Name: "name", Type: ArgWord, Optional: true}},
+		Run: func(sender CommandSender, args Args) error {
+			if !args.Has(0) {
+				sendChat(sender, ChatInfo+"Warps: "+ChatPayload+strings.Join(storage.Warps(), ", "))
+				return nil
+			}
+
+			self, ok := sender.(*_player)
+			if !ok {
+				return ErrNotPlayer
+			}
+
+			warp, ok := storage.GetWarp(args.String(0))
+			if !ok {
+				return fmt.Errorf("There is no warp named %s.", args.String(0))
+			}
+			self.teleport(warp.X, warp.Y, warp.Z, warp.Yaw, warp.Pitch)
+			return nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:        "setwarp",
+		Description: "Create a warp where you are standing, or move an existing one.",
+		Permission:  "stuzzd.command.setwarp",
+		Args:        []Arg{{Name: "name", Type: ArgWord}},
+		Run: func(sender CommandSender, args Args) error {
+			self, ok := sender.(*_player)
+			if !ok {
+				return ErrNotPlayer
+			}
+
+			var warp storage.Warp
+			warp.X, warp.Y, warp.Z = self.Position()
+			warp.Yaw, warp.Pitch = self.Angles()
+			if err := storage.SetWarp(args.String(0), warp); err != nil {
+				return fmt.Errorf("The warp could not be saved: %v", err)
+			}
+			sendChat(sender, ChatInfo+"Warp "+ChatPayload+strings.ToLower(args.String(0))+ChatInfo+" set.")
+			return nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:        "delwarp",
+		Description: "Delete a warp.",
+		Permission:  "stuzzd.command.setwarp",
+		Args:        []Arg{{Name: "name", Type: ArgWord}},
+		Run: func(sender CommandSender, args Args) error {
+			deleted, err := storage.DeleteWarp(args.String(0))
+			if err != nil {
+				return fmt.Errorf("The warps could not be saved: %v", err)
+			}
+			if !deleted {
+				return fmt.Errorf("There is no warp named %s.", args.String(0))
+			}
+			sendChat(sender, ChatInfo+"Warp deleted.")
+			return nil
+		},
+	})
+}
+
+// /tp <player>, /tp <x> <y> <z>, /tp <player> <target> or /tp <player> <x> <y> <z>
+func commandTeleport(sender CommandSender, args Args) error {
+	words := strings.Fields(args.String(0))
+
+	var target *_player
+	if len(words) == 2 || len(words) == 4 {
+		p := FindPlayer(words[0])
+		if p == nil {
+			return fmt.Errorf("Could not find player %s.", words[0])
+		}
+		target = p.(*_player)
+		words = words[1:]
+	} else if self, ok := sender.(*_player); ok {
+		target = self
+	} else {
+		return UsageError("The console has to say who to teleport.")
+	}
+
+	switch len(words) {
+	case 1:
+		destination := FindPlayer(words[0])
+		if destination == nil {
+			return fmt.Errorf("Could not find player %s.", words[0])
+		}
+		target.teleportTo(destination)
+	case 3:
+		coords, err := parseCoordinates(sender, words)
+		if err != nil {
+			return err
+		}
+		yaw, pitch := target.Angles()
+		target.teleport(coords.X, coords.Y, coords.Z, yaw, pitch)
+	default:
+		return UsageError("")
+	}
+
+	if target != sender {
+		sendChat(target, formatUsername(sender)+" teleported you.")
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package networking
+
+import (
+	"fmt"
+	"github.com/Nightgunner5/stuzzd/config"
+	"github.com/Nightgunner5/stuzzd/protocol"
+	"github.com/Nightgunner5/stuzzd/scheduler"
+	"github.com/Nightgunner5/stuzzd/storage"
+	"math"
+	"strconv"
+)
+
+const (
+	dayLength = 24000
+
+	// Times of day, in ticks since sunrise.
+	dayStart   = 0
+	nightStart = 13000
+
+	// How often clients are told the time. Clients keep their own clock in between, so when the time of day is
+	// stopped they are told every tick instead.
+	timeUpdateInterval = 100
+)
+
+func timeUpdate() protocol.TimeUpdate {
+	_, dayTime := storage.WorldTime()
+	return protocol.TimeUpdate{Time: uint64(dayTime)}
+}
+
+// Sets the time of day and tells everyone about it.
+func setDayTime(dayTime int64) {
+	storage.SetDayTime(dayTime)
+	SendToAll(timeUpdate())
+}
+
+// How far the sun has gone around the sky, from 0 at noon to 0.5 at midnight. Calculated the same way as vanilla.
+func celestialAngle(dayTime int64) float64 {
+	angle := float64(dayTime%dayLength)/dayLength - 0.25
+	if angle < 0 {
+		angle++
+	}
+	return angle + ((1-(math.Cos(angle*math.Pi)+1)/2)-angle)/3
+}
+
+// How much the light from the sky is dimmed at the time of day, from 0 during the day to 11 at night.
+func skyDarkness(dayTime int64) uint8 {
+	darkness := 1 - (math.Cos(celestialAngle(dayTime)*2*math.Pi)*2 + 0.5)
+	return uint8(math.Max(0, math.Min(1, darkness)) * 11)
+}
+
+// Vanilla's idea of when it is day: the sky is bright enough that undead mobs burn.
+func isDaytime() bool {
+	_, dayTime := storage.WorldTime()
+	return skyDarkness(dayTime) < 4
+}
+
+// Returns the next time after now that is at the given point in the day.
+func nextTimeOfDay(now, timeOfDay int64) int64 {
+	next := now - now%dayLength + timeOfDay
+	if next <= now {
+		next += dayLength
+	}
+	return next
+}
+
+func init() {
+	scheduler.EveryTick("world time", func() {
+		storage.AdvanceTime(config.Config.DaylightCycle)
+	})
+	scheduler.EveryTick("time update", func() {
+		if config.Config.DaylightCycle && config.CurrentTick()%timeUpdateInterval != 0 {
+			return
+		}
+		SendToAll(timeUpdate())
+	})
+
+	RegisterCommand(&Command{
+		Name:        "day",
+		Description: "Advance to the next day.",
+		Permission:  "stuzzd.command.time",
+		Run: func(sender CommandSender, args Args) error {
+			_, now := storage.WorldTime()
+			setDayTime(nextTimeOfDay(now, dayStart))
+			return nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:        "night",
+		Description: "Advance to the next night.",
+		Permission:  "stuzzd.command.time",
+		Run: func(sender CommandSender, args Args) error {
+			_, now := storage.WorldTime()
+			setDayTime(nextTimeOfDay(now, nightStart))
+			return nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:        "time",
+		Description: "Set the time of day (a number of ticks, day or night) or move it forward.",
+		Permission:  "stuzzd.command.time",
+		Args:        []Arg{{Name: "set|add", Type: ArgWord}, {Name: "value", Type: ArgWord}},
+		Run: func(sender CommandSender, args Args) error {
+			_, now := storage.WorldTime()
+
+			// The time only ever moves forward. Clients don't handle it going backwards.
+			var dayTime int64
+			switch args.String(0) {
+			case "set":
+				switch args.String(1) {
+				case "day":
+					dayTime = nextTimeOfDay(now, dayStart)
+				case "night":
+					dayTime = nextTimeOfDay(now, nightStart)
+				default:
+					t, err := strconv.ParseInt(args.String(1), 10, 64)
+					if err != nil || t < 0 {
+						return UsageError(args.String(1) + " is not a time.")
+					}
+					dayTime = nextTimeOfDay(now, t%dayLength)
+				}
+			case "add":
+				t, err := strconv.ParseInt(args.String(1), 10, 64)
+				if err != nil || t < 0 {
+					return UsageError(args.String(1) + " is not a number of ticks.")
+				}
+				dayTime = now + t
+			default:
+				return UsageError("")
+			}
+
+			setDayTime(dayTime)
+			sendChat(sender, fmt.Sprintf("%sThe time is now %s%d%s.", ChatInfo, ChatPayload, dayTime%dayLength, ChatInfo))
+			return nil
+		},
+	})
+}
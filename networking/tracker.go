@@ -0,0 +1,134 @@
+package networking
+
+import (
+	"bytes"
+	"github.com/Nightgunner5/stuzzd/config"
+	"github.com/Nightgunner5/stuzzd/protocol"
+	"math"
+)
+
+// Each player keeps a set of the entities their client knows about. The tracker spawns entities on the client
+// as they come within config.Config.EntityTrackingRadius blocks of the player (and the player has the chunk they
+// are in) and destroys them as they leave. Movement and animation packets only go to players tracking the entity.
+// Everything the tracker sends goes through each player's ordered queue, so it reaches the client in order.
+
+func updateTracking() {
+	online := registered.onlinePlayers()
+	for _, observer := range online {
+		o := observer.(*_player)
+		if !o.isSpawned() {
+			continue
+		}
+		o.setTracked(o.visibleEntities(online))
+	}
+}
+
+// The caller must not hold the player's chunk lock.
+func (p *_player) canSeePosition(x, z float64) bool {
+	px, _, pz := p.Position()
+	radius := float64(config.Config.EntityTrackingRadius)
+	if math.Abs(x-px) > radius || math.Abs(z-pz) > radius {
+		return false
+	}
+	return p.hasChunk(int32(math.Floor(x))>>4, int32(math.Floor(z))>>4)
+}
+
+func (p *_player) visibleEntities(online []Player) map[int32]Entity {
+	visible := make(map[int32]Entity)
+
+	for _, other := range online {
+		if other == Player(p) || !other.(*_player).isSpawned() || other.(*_player).isDead() {
+			continue
+		}
+		if x, _, z := other.Position(); p.canSeePosition(x, z) {
+			visible[other.ID()] = other
+		}
+	}
+
+	px, _, pz := p.Position()
+	radius := config.Config.EntityTrackingRadius
+	minX, maxX := (int32(math.Floor(px))-radius)>>4, (int32(math.Floor(px))+radius)>>4
+	minZ, maxZ := (int32(math.Floor(pz))-radius)>>4, (int32(math.Floor(pz))+radius)>>4
+	for cx := minX; cx <= maxX; cx++ {
+		for cz := minZ; cz <= maxZ; cz++ {
+			c := p.loadedChunk(cx, cz)
+			if c == nil {
+				continue
+			}
+			for _, ent := range c.GetEntities() {
+				if !ent.Spawnable() {
+					continue
+				}
+				if x, _, z := ent.Position(); p.canSeePosition(x, z) {
+					visible[ent.ID()] = ent
+				}
+			}
+		}
+	}
+
+	return visible
+}
+
+// Replaces the set of entities the player is tracking, spawning and destroying entities on the client as needed.
+func (p *_player) setTracked(visible map[int32]Entity) {
+	var buf bytes.Buffer
+
+	p.trackLock.Lock()
+	for id := range p.tracked {
+		if _, ok := visible[id]; !ok {
+			buf.Write(protocol.DestroyEntity{ID: id}.Packet())
+		}
+	}
+	for id, ent := range visible {
+		if _, ok := p.tracked[id]; !ok && !ent.SpawnPacket(&buf) {
+			delete(visible, id)
+		}
+	}
+	p.tracked = visible
+	p.trackLock.Unlock()
+
+	if buf.Len() != 0 {
+		p.queuePacket(protocol.BakedPacket(buf.Bytes()))
+	}
+}
+
+func (p *_player) isTracking(id int32) bool {
+	p.trackLock.Lock()
+	defer p.trackLock.Unlock()
+
+	_, ok := p.tracked[id]
+	return ok
+}
+
+// Returns the entity with the given ID if the player's client knows about it.
+func (p *_player) trackedEntity(id int32) Entity {
+	p.trackLock.Lock()
+	defer p.trackLock.Unlock()
+
+	return p.tracked[id]
+}
+
+// Sends a packet to every player whose client knows about the given entity.
+func sendToObservers(id int32, packet protocol.Packet) {
+	baked := protocol.BakePacket(packet)
+	for _, player := range registered.onlinePlayers() {
+		if player.(*_player).isTracking(id) {
+			player.(*_player).queuePacket(baked)
+		}
+	}
+}
+
+// Removes an entity from every player that is tracking it and destroys it on their clients.
+func untrack(id int32) {
+	baked := protocol.BakePacket(protocol.DestroyEntity{ID: id})
+	for _, player := range registered.onlinePlayers() {
+		p := player.(*_player)
+		p.trackLock.Lock()
+		_, ok := p.tracked[id]
+		delete(p.tracked, id)
+		p.trackLock.Unlock()
+		if ok {
+			p.queuePacket(baked)
+		}
+	}
+}
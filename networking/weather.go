@@ -0,0 +1,170 @@
+package networking
+
+import (
+	"github.com/Nightgunner5/stuzzd/block"
+	"github.com/Nightgunner5/stuzzd/protocol"
+	"github.com/Nightgunner5/stuzzd/scheduler"
+	"github.com/Nightgunner5/stuzzd/storage"
+	"math"
+	"math/rand"
+)
+
+const (
+	// How long clear weather and rain last, in ticks. The same as vanilla.
+	minClearTicks = 12000
+	maxClearTicks = 180000
+	minRainTicks  = 12000
+	maxRainTicks  = 24000
+
+	// How likely lightning is to strike near each player on each tick of a thunderstorm.
+	lightningChance = 400
+)
+
+// Returns how many ticks the current rain (or thunder) or clear spell should last.
+func weatherLength(active bool) int32 {
+	if active {
+		return int32(minRainTicks + rand.Intn(maxRainTicks-minRainTicks))
+	}
+	return int32(minClearTicks + rand.Intn(maxClearTicks-minClearTicks))
+}
+
+func rainPacket(raining bool) protocol.ChangeGameState {
+	if raining {
+		return protocol.ChangeGameState{Type: protocol.StartRaining}
+	}
+	return protocol.ChangeGameState{Type: protocol.StopRaining}
+}
+
+func tickWeather() {
+	var weather storage.Weather
+	var wasRaining bool
+	storage.UpdateWeather(func(w *storage.Weather) {
+		wasRaining = w.Raining
+
+		// A new world starts with no time left on either, so they pick a length without changing first.
+		if w.RainTime <= 0 {
+			w.RainTime = weatherLength(w.Raining)
+		} else if w.RainTime--; w.RainTime == 0 {
+			w.Raining = !w.Raining
+		}
+		if w.ThunderTime <= 0 {
+			w.ThunderTime = weatherLength(w.Thundering)
+		} else if w.ThunderTime--; w.ThunderTime == 0 {
+			w.Thundering = !w.Thundering
+		}
+
+		weather = *w
+	})
+
+	if weather.Raining != wasRaining {
+		SendToAll(rainPacket(weather.Raining))
+	}
+
+	if weather.Raining && weather.Thundering {
+		for _, p := range registered.onlinePlayers() {
+			if rand.Intn(lightningChance) == 0 {
+				p.(*_player).strikeNear()
+			}
+		}
+	}
+}
+
+// Strikes lightning somewhere in the chunks the player can see.
+func (p *_player) strikeNear() {
+	x, _, z := p.Position()
+	distance := int(viewDistance()) * 16
+	bx := int32(math.Floor(x)) + int32(rand.Intn(2*distance+1)-distance)
+	bz := int32(math.Floor(z)) + int32(rand.Intn(2*distance+1)-distance)
+
+	c := p.loadedChunk(bx>>4, bz>>4)
+	if c == nil {
+		return
+	}
+	strikeLightning(bx, c.GetHighestBlockYAt(bx, bz)+1, bz)
+}
+
+// Strikes lightning at x, y, z, setting fire to that block if it is air with something solid under it.
+func strikeLightning(x, y, z int32) {
+	SendToAllNearChunk(x>>4, z>>4, protocol.Thunderbolt{ID: assignID(), X: float64(x), Y: float64(y), Z: float64(z)})
+
+	if GetBlockAt(x, y, z) == block.Air && !GetBlockAt(x, y-1, z).Passable() {
+		PlayerSetBlockAt(x, y, z, block.Fire, 0)
+	}
+}
+
+func setWeather(raining, thundering bool) {
+	changeWeather(func(storage.Weather) (bool, bool) {
+		return raining, thundering
+	})
+}
+
+// Starts or stops the rain. Returns true if it is now raining.
+func toggleDownfall() bool {
+	var raining bool
+	changeWeather(func(old storage.Weather) (bool, bool) {
+		raining = !old.Raining
+		return raining, false
+	})
+	return raining
+}
+
+// Replaces the weather with what choose picks based on the current weather, and tells everyone if the rain
+// started or stopped.
+func changeWeather(choose func(storage.Weather) (raining, thundering bool)) {
+	var wasRaining, raining bool
+	storage.UpdateWeather(func(w *storage.Weather) {
+		wasRaining = w.Raining
+
+		var thundering bool
+		raining, thundering = choose(*w)
+		w.Raining, w.Thundering = raining, thundering
+		w.RainTime = weatherLength(raining)
+		if thundering {
+			w.ThunderTime = w.RainTime
+		} else {
+			w.ThunderTime = weatherLength(false)
+		}
+	})
+
+	if raining != wasRaining {
+		SendToAll(rainPacket(raining))
+	}
+}
+
+func init() {
+	scheduler.EveryTick("weather", tickWeather)
+
+	RegisterCommand(&Command{
+		Name:        "weather",
+		Description: "Change the weather to clear, rain or thunder.",
+		Permission:  "stuzzd.command.weather",
+		Args:        []Arg{{Name: "clear|rain|thunder", Type: ArgWord}},
+		Run: func(sender CommandSender, args Args) error {
+			switch args.String(0) {
+			case "clear":
+				setWeather(false, false)
+			case "rain":
+				setWeather(true, false)
+			case "thunder":
+				setWeather(true, true)
+			default:
+				return UsageError("")
+			}
+			sendChat(sender, ChatInfo+"Changed the weather to "+ChatPayload+args.String(0)+ChatInfo+".")
+			return nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:        "toggledownfall",
+		Description: "Start or stop the rain.",
+		Permission:  "stuzzd.command.weather",
+		Run: func(sender CommandSender, args Args) error {
+			if toggleDownfall() {
+				sendChat(sender, ChatInfo+"It is now raining.")
+			} else {
+				sendChat(sender, ChatInfo+"It has stopped raining.")
+			}
+			return nil
+		},
+	})
+}
@@ -1,17 +1,17 @@
 package networking
 
 import (
-	"bytes"
 	"github.com/Nightgunner5/stuzzd/block"
 	"github.com/Nightgunner5/stuzzd/chunk"
 	"github.com/Nightgunner5/stuzzd/player"
 	"github.com/Nightgunner5/stuzzd/protocol"
+	"github.com/Nightgunner5/stuzzd/scheduler"
 	"github.com/Nightgunner5/stuzzd/storage"
-	"log"
+	"math"
+	"math/rand"
 	"runtime"
 	"sort"
 	"sync"
-	"time"
 )
 
 func GetBlockAt(x, y, z int32) block.BlockType {
@@ -24,6 +24,54 @@ func GetBlockAt(x, y, z int32) block.BlockType {
 	return chunk.GetBlock(x, y, z)
 }
 
+// Like GetBlockAt, but never loads a chunk. Blocks in chunks that aren't loaded are reported as stone, so
+// anything moving or pathfinding on the tick goroutine treats the edge of the loaded world as a wall.
+func loadedBlockAt(x, y, z int32) block.BlockType {
+	if y < 0 || y > 255 {
+		return block.Air
+	}
+	chunk := storage.LoadedChunk(x>>4, z>>4)
+	if chunk == nil {
+		return block.Stone
+	}
+	defer storage.ReleaseChunkContaining(x, z)
+
+	return chunk.GetBlock(x, y, z)
+}
+
+// The light at a block from the sky at the current time of day.
+func GetSkyLightAt(x, y, z int32) uint8 {
+	chunk := storage.GetChunkContaining(x, z)
+	defer storage.ReleaseChunkContaining(x, z)
+
+	light := chunk.GetSkyLight(x, y, z)
+	_, dayTime := storage.WorldTime()
+	if darkness := skyDarkness(dayTime); light > darkness {
+		return light - darkness
+	}
+	return 0
+}
+
+// The light at a block, from the sky or from glowing blocks, whichever is brighter.
+func GetLightAt(x, y, z int32) uint8 {
+	chunk := storage.GetChunkContaining(x, z)
+	blockLight := chunk.GetBlockLight(x, y, z)
+	storage.ReleaseChunkContaining(x, z)
+
+	if skyLight := GetSkyLightAt(x, y, z); skyLight > blockLight {
+		return skyLight
+	}
+	return blockLight
+}
+
+// Reports whether there is nothing but air above a block.
+func canSeeSky(x, y, z int32) bool {
+	chunk := storage.GetChunkContaining(x, z)
+	defer storage.ReleaseChunkContaining(x, z)
+
+	return chunk.GetHighestBlockYAt(x, z) < y
+}
+
 func GetBlockDataAt(x, y, z int32) uint8 {
 	if y < 0 || y > 255 {
 		return 0
@@ -63,15 +111,98 @@ func SetBlockAt(x, y, z int32, block block.BlockType, data uint8) {
 }
 
 func DropItem(x, y, z float64, itemType int16, data uint8) {
-	c := storage.GetChunkContaining(int32(x), int32(z))
-	defer storage.ReleaseChunkContaining(int32(x), int32(z))
+	dropStack(x, y, z, &player.InventoryItem{Type: itemType, Damage: int16(data), Count: 1}, 10)
+}
+
+// Spawns an item entity that can be picked up after the given number of ticks.
+func dropStack(x, y, z float64, item *player.InventoryItem, pickupDelay int16) {
+	c := storage.GetChunkContaining(int32(math.Floor(x)), int32(math.Floor(z)))
+	defer storage.ReleaseChunkContaining(int32(math.Floor(x)), int32(math.Floor(z)))
 
-	ent := chunk.NewItemDrop(x, y, z, &player.InventoryItem{Type: itemType, Damage: int16(data), Count: 1})
+	ent := chunk.NewItemDrop(x, y, z, item)
+	ent.SetPickupDelay(pickupDelay)
 	c.SpawnEntity(chunk.Entity(ent))
+	// The entity tracker will spawn it for nearby players on the next tick.
+}
+
+// The furthest a player can be from the center of a block and still place a block against it.
+const maxReach = 6
+
+func placeBlock(p Player, pkt protocol.PlayerBlockPlacement) {
+	if pkt.Direction == protocol.FaceNone {
+		// Using an item in the air, not placing anything. Food is the only item that does something.
+		p.(*_player).startEating()
+		return
+	}
+
+	dx, dy, dz := pkt.Direction.Offset()
+	x, y, z := pkt.X, int32(pkt.Y), pkt.Z
+	if !GetBlockAt(x, y, z).Replaceable() {
+		x, y, z = x+dx, y+dy, z+dz
+	}
+
+	if !tryPlaceBlock(p, pkt, x, y, z) {
+		// The client already shows the block as placed, so tell it what is actually there.
+		p.SendPacketSync(protocol.BlockChange{X: x, Y: uint8(y), Z: z, Block: GetBlockAt(x, y, z), Data: GetBlockDataAt(x, y, z)})
+	}
+}
+
+func tryPlaceBlock(p Player, pkt protocol.PlayerBlockPlacement, x, y, z int32) bool {
+	if pkt.Item <= 0 || pkt.Item > int16(block.RedstoneLampOn) {
+		return false // TODO: items that place blocks, such as doors and redstone.
+	}
+	blockType := block.BlockType(pkt.Item)
+
+	if y < 0 || y > 255 {
+		return false
+	}
+
+	if !p.(*_player).stored.Abilities.MayBuild {
+		return false // Adventure mode
+	}
+
+	px, py, pz := p.Position()
+	cx, cy, cz := float64(pkt.X)+0.5, float64(pkt.Y)+0.5, float64(pkt.Z)+0.5
+	if (px-cx)*(px-cx)+(py+1.62-cy)*(py+1.62-cy)+(pz-cz)*(pz-cz) > maxReach*maxReach {
+		return false
+	}
+
+	if !GetBlockAt(x, y, z).Replaceable() {
+		return false
+	}
+
+	if !blockType.Passable() {
+		for _, player := range registered.onlinePlayers() {
+			if intersectsBlock(player, x, y, z) {
+				return false
+			}
+		}
+	}
+
+	pl := p.(*_player)
+	if !pl.stored.Abilities.InstaBuild {
+		pl.inventoryLock.Lock()
+		defer pl.inventoryLock.Unlock()
+
+		held := pl.heldItem()
+		if held == nil || held.Type != pkt.Item || held.Damage != pkt.Damage {
+			pl.sendInventory()
+			return false
+		}
+		pl.stored.RemoveItem(int8(pl.heldSlot), 1)
+	}
+
+	// TODO: orientation data for torches, stairs, etc.
+	PlayerSetBlockAt(x, y, z, blockType, uint8(pkt.Damage)&0xF)
+	return true
+}
 
-	var buf bytes.Buffer
-	ent.SpawnPacket(&buf)
-	SendToAllNearChunk(c.X, c.Z, protocol.BakedPacket(buf.Bytes()))
+// Checks whether a player's bounding box overlaps a block.
+func intersectsBlock(p Player, x, y, z int32) bool {
+	px, py, pz := p.Position()
+	return px+0.3 > float64(x) && px-0.3 < float64(x+1) &&
+		py+1.8 > float64(y) && py < float64(y+1) &&
+		pz+0.3 > float64(z) && pz-0.3 < float64(z+1)
 }
 
 func setBlockNoUpdate(x, y, z int32, block block.BlockType, data uint8) {
@@ -85,6 +216,9 @@ func setBlockNoUpdate(x, y, z int32, block block.BlockType, data uint8) {
 	chunk.SetData(x, y, z, data)
 }
 
+// How long fire burns for on average.
+const fireBurnTicks = 40
+
 func startBlockUpdate(x, y, z int32) {
 	for X := x - 1; X <= x+1; X++ {
 		for Y := y - 1; Y <= y+1; Y++ {
@@ -103,6 +237,9 @@ func startBlockUpdate(x, y, z int32) {
 
 				case block.Gravel, block.Sand, block.LongGrass, block.RedFlower, block.YellowFlower:
 					queueUpdate(X, Y, Z)
+
+				case block.Fire:
+					queueUpdate(X, Y, Z)
 				}
 
 				storage.ReleaseChunkContaining(X, Z)
@@ -239,67 +376,77 @@ func queueUpdate(x, y, z int32) {
 	updateQueue[struct{ x, y, z int32 }{x, y, z}] = true
 }
 
-func ticker() {
-	for {
-		time.Sleep(50 * time.Millisecond)
-
-		updateLock.Lock()
+func tickBlocks() {
+	updateLock.Lock()
 
-		queue := updateQueue
-		updateQueue = make(map[struct{ x, y, z int32 }]bool)
+	queue := updateQueue
+	updateQueue = make(map[struct{ x, y, z int32 }]bool)
 
-		updateLock.Unlock()
+	updateLock.Unlock()
 
-		updateCount := 0
+	updateCount := 0
+	var stillBurning []struct{ x, y, z int32 }
 
-		for loc, _ := range queue {
-			x, y, z := loc.x, loc.y, loc.z
-			blockType := GetBlockAt(x, y, z)
-			switch blockType {
-			case block.Water:
-				if !spreadWater(x, y, z) && GetBlockAt(x, y, z) == block.Water {
-					setBlockNoUpdate(x, y, z, block.StationaryWater, GetBlockDataAt(x, y, z))
-				}
-			case block.Sand, block.Gravel, block.LongGrass, block.RedFlower, block.YellowFlower:
-				if GetBlockAt(x, y-1, z).Passable() {
-					blockData := GetBlockDataAt(x, y, z)
-					SetBlockAt(x, y, z, GetBlockAt(x, y-1, z), GetBlockDataAt(x, y-1, z))
-					SetBlockAt(x, y-1, z, blockType, blockData)
-				}
-			case block.Sponge:
-				switch GetBlockAt(x, y+1, z) {
-				case block.Water, block.StationaryWater:
-					decrementWater(x, y+1, z)
-				}
+	for loc, _ := range queue {
+		x, y, z := loc.x, loc.y, loc.z
+		blockType := GetBlockAt(x, y, z)
+		switch blockType {
+		case block.Water:
+			if !spreadWater(x, y, z) && GetBlockAt(x, y, z) == block.Water {
+				setBlockNoUpdate(x, y, z, block.StationaryWater, GetBlockDataAt(x, y, z))
 			}
-			updateCount++
-			delete(queue, loc)
-			runtime.Gosched() // Don't cause too much lag
-			if updateCount >= 10000 {
-				log.Print("> 10000 updates. Waiting for the next tick to resume updating.")
-				break
+		case block.Sand, block.Gravel, block.LongGrass, block.RedFlower, block.YellowFlower:
+			if GetBlockAt(x, y-1, z).Passable() {
+				blockData := GetBlockDataAt(x, y, z)
+				SetBlockAt(x, y, z, GetBlockAt(x, y-1, z), GetBlockDataAt(x, y-1, z))
+				SetBlockAt(x, y-1, z, blockType, blockData)
+			}
+		case block.Sponge:
+			switch GetBlockAt(x, y+1, z) {
+			case block.Water, block.StationaryWater:
+				decrementWater(x, y+1, z)
+			}
+		case block.Fire:
+			// Fire doesn't spread yet, so it just burns for a while.
+			if rand.Intn(fireBurnTicks) == 0 || GetBlockAt(x, y-1, z).Passable() {
+				SetBlockAt(x, y, z, block.Air, 0)
+			} else {
+				stillBurning = append(stillBurning, loc)
 			}
 		}
-
-		for loc, _ := range queue {
-			queueUpdate(loc.x, loc.y, loc.z)
+		updateCount++
+		delete(queue, loc)
+		runtime.Gosched() // Don't cause too much lag
+		if updateCount >= 10000 {
+			// The rest are carried over to the next tick.
+			break
 		}
+	}
 
-		blockSendLock.Lock()
-		for chunk, blocks := range blockSendQueue {
-			c := storage.GetChunk(chunk.x, chunk.z)
-			packet := protocol.MultiBlockChange{X: chunk.x, Z: chunk.z, Blocks: make([]uint32, 0, len(blocks))}
-			for block, _ := range blocks {
-				packet.Blocks = append(packet.Blocks, uint32(block.x&0xF)<<28|uint32(block.z&0xF)<<24|uint32(block.y)<<16|uint32(c.GetBlock(block.x, block.y, block.z))<<4|uint32(c.GetData(block.x, block.y, block.z)))
-			}
-			storage.ReleaseChunk(chunk.x, chunk.z)
-			SendToAll(packet)
-			delete(blockSendQueue, chunk)
+	for loc, _ := range queue {
+		queueUpdate(loc.x, loc.y, loc.z)
+	}
+	for _, loc := range stillBurning {
+		queueUpdate(loc.x, loc.y, loc.z)
+	}
+
+	blockSendLock.Lock()
+	for chunk, blocks := range blockSendQueue {
+		c := storage.GetChunk(chunk.x, chunk.z)
+		packet := protocol.MultiBlockChange{X: chunk.x, Z: chunk.z, Blocks: make([]uint32, 0, len(blocks))}
+		for block, _ := range blocks {
+			packet.Blocks = append(packet.Blocks, uint32(block.x&0xF)<<28|uint32(block.z&0xF)<<24|uint32(block.y)<<16|uint32(c.GetBlock(block.x, block.y, block.z))<<4|uint32(c.GetData(block.x, block.y, block.z)))
 		}
-		blockSendLock.Unlock()
+		storage.ReleaseChunk(chunk.x, chunk.z)
+		SendToAll(packet)
+		delete(blockSendQueue, chunk)
 	}
+	blockSendLock.Unlock()
 }
 
 func init() {
-	go ticker()
+	scheduler.EveryTick("block updates", tickBlocks)
+	scheduler.EveryTick("entities", tickEntities)
+	scheduler.EveryTick("item pickup", pickupItems)
+	scheduler.EveryTick("entity tracking", updateTracking)
 }
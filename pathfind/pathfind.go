@@ -0,0 +1,175 @@
+// Package pathfind finds walking routes over the block grid with A*.
+package pathfind
+
+import (
+	"container/heap"
+	"github.com/Nightgunner5/stuzzd/block"
+	"math"
+)
+
+// A block position. A walker standing at a Point has its feet in that block.
+type Point struct {
+	X, Y, Z int32
+}
+
+// Returns the block at a position. The pathfinder only looks at blocks near the route it is searching.
+type World func(x, y, z int32) block.BlockType
+
+// How a walker moves.
+type Walker struct {
+	Height   int32 // How many blocks tall it is
+	MaxDrop  int32 // The furthest it will drop down in one step
+	MaxNodes int   // How many positions to search before giving up
+}
+
+var neighbors = [...]Point{{1, 0, 0}, {-1, 0, 0}, {0, 0, 1}, {0, 0, -1}}
+
+// Returns a path from start to goal, not including start, or nil if there is none within the walker's search
+// limit. If goal can't be reached, the path to the closest position found is returned along with false.
+func (w Walker) Find(world World, start, goal Point) (path []Point, complete bool) {
+	open := &nodeHeap{}
+	nodes := map[Point]*node{start: {Point: start, estimate: distance(start, goal)}}
+	heap.Push(open, nodes[start])
+	closest := nodes[start]
+
+	for searched := 0; open.Len() > 0 && searched < w.MaxNodes; searched++ {
+		current := heap.Pop(open).(*node)
+		current.closed = true
+
+		if current.Point == goal {
+			return current.path(), true
+		}
+		if current.estimate-current.cost < closest.estimate-closest.cost {
+			closest = current
+		}
+
+		for _, step := range w.steps(world, current.Point) {
+			cost := current.cost + distance(current.Point, step)
+			next, ok := nodes[step]
+			if !ok {
+				next = &node{Point: step, index: -1}
+				nodes[step] = next
+			} else if next.closed || cost >= next.cost {
+				continue
+			}
+			next.parent, next.cost = current, cost
+			next.estimate = cost + distance(step, goal)
+			if next.index < 0 {
+				heap.Push(open, next)
+			} else {
+				heap.Fix(open, next.index)
+			}
+		}
+	}
+
+	if closest == nodes[start] {
+		return nil, false
+	}
+	return closest.path(), false
+}
+
+// Returns the positions the walker can move to from p in one step.
+func (w Walker) steps(world World, p Point) []Point {
+	var steps []Point
+	for _, n := range neighbors {
+		x, z := p.X+n.X, p.Z+n.Z
+
+		if w.canStand(world, Point{x, p.Y, z}) {
+			steps = append(steps, Point{x, p.Y, z})
+			continue
+		}
+
+		// Jump up a block. There has to be room above the walker's head to jump.
+		if w.clear(world, Point{p.X, p.Y + w.Height, p.Z}, 1) && w.canStand(world, Point{x, p.Y + 1, z}) {
+			steps = append(steps, Point{x, p.Y + 1, z})
+			continue
+		}
+
+		// Drop down.
+		if !w.clear(world, Point{x, p.Y, z}, w.Height) {
+			continue
+		}
+		for y := p.Y - 1; y >= p.Y-w.MaxDrop && y >= 0; y-- {
+			if w.canStand(world, Point{x, y, z}) {
+				steps = append(steps, Point{x, y, z})
+				break
+			}
+			if !w.clear(world, Point{x, y, z}, 1) {
+				break
+			}
+		}
+	}
+	return steps
+}
+
+// Reports whether the walker fits at p with something to stand on (or water to swim in).
+func (w Walker) canStand(world World, p Point) bool {
+	if !w.clear(world, p, w.Height) {
+		return false
+	}
+	if world(p.X, p.Y, p.Z).IsWater() {
+		return true
+	}
+	below := world(p.X, p.Y-1, p.Z)
+	return !below.Passable() || below.IsWater()
+}
+
+// Reports whether the height blocks from p upward can be walked through. Lava can't.
+func (w Walker) clear(world World, p Point, height int32) bool {
+	for y := p.Y; y < p.Y+height; y++ {
+		b := world(p.X, y, p.Z)
+		if !b.Passable() || b.SemiPassable() || b.IsLava() || b == block.Fire {
+			return false
+		}
+	}
+	return true
+}
+
+func distance(a, b Point) float64 {
+	dx, dy, dz := float64(a.X-b.X), float64(a.Y-b.Y), float64(a.Z-b.Z)
+	return math.Sqrt(dx*dx + dy*dy + dz*dz)
+}
+
+type node struct {
+	Point
+	parent   *node
+	cost     float64 // From the start
+	estimate float64 // Cost plus the distance left to the goal
+	index    int     // In the heap, or -1 if it isn't in it
+	closed   bool
+}
+
+// Returns the points from the start to n, not including the start.
+func (n *node) path() []Point {
+	var path []Point
+	for ; n.parent != nil; n = n.parent {
+		path = append(path, n.Point)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+type nodeHeap []*node
+
+func (h nodeHeap) Len() int           { return len(h) }
+func (h nodeHeap) Less(i, j int) bool { return h[i].estimate < h[j].estimate }
+func (h nodeHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *nodeHeap) Push(x interface{}) {
+	n := x.(*node)
+	n.index = len(*h)
+	*h = append(*h, n)
+}
+
+func (h *nodeHeap) Pop() interface{} {
+	old := *h
+	n := old[len(old)-1]
+	n.index = -1
+	*h = old[:len(old)-1]
+	return n
+}
@@ -0,0 +1,170 @@
+package pathfind
+
+import (
+	"github.com/Nightgunner5/stuzzd/block"
+	"testing"
+)
+
+// A world that is air except for the stone blocks in it.
+type testWorld map[Point]bool
+
+// Adds a row of stone along the X axis at the given height.
+func (w testWorld) row(x0, x1, y int32) testWorld {
+	for x := x0; x <= x1; x++ {
+		w[Point{x, y, 0}] = true
+	}
+	return w
+}
+
+func (w testWorld) block(x, y, z int32) block.BlockType {
+	if w[Point{x, y, z}] {
+		return block.Stone
+	}
+	return block.Air
+}
+
+var walker = Walker{Height: 2, MaxDrop: 3, MaxNodes: 1000}
+
+var findTests = []struct {
+	name     string
+	walker   Walker
+	world    testWorld
+	start    Point
+	goal     Point
+	complete bool
+	end      *Point // Where the path should finish, or nil if there should be no path
+}{
+	{
+		name:     "flat",
+		walker:   walker,
+		world:    testWorld{}.row(0, 5, 0),
+		start:    Point{0, 1, 0},
+		goal:     Point{5, 1, 0},
+		complete: true,
+		end:      &Point{5, 1, 0},
+	},
+	{
+		name:     "gap",
+		walker:   walker,
+		world:    testWorld{}.row(0, 3, 0).row(6, 8, 0),
+		start:    Point{0, 1, 0},
+		goal:     Point{7, 1, 0},
+		complete: false,
+		end:      &Point{3, 1, 0},
+	},
+	{
+		name:     "already there",
+		walker:   walker,
+		world:    testWorld{}.row(0, 0, 0),
+		start:    Point{0, 1, 0},
+		goal:     Point{0, 1, 0},
+		complete: true,
+		end:      nil,
+	},
+	{
+		name:     "nowhere to go",
+		walker:   walker,
+		world:    testWorld{}.row(0, 0, 0),
+		start:    Point{0, 1, 0},
+		goal:     Point{5, 1, 0},
+		complete: false,
+		end:      nil,
+	},
+	{
+		name:     "jump one block",
+		walker:   walker,
+		world:    testWorld{}.row(0, 4, 0).row(3, 4, 1),
+		start:    Point{0, 1, 0},
+		goal:     Point{4, 2, 0},
+		complete: true,
+		end:      &Point{4, 2, 0},
+	},
+	{
+		name:     "jump two blocks",
+		walker:   walker,
+		world:    testWorld{}.row(0, 4, 0).row(3, 4, 1).row(3, 4, 2),
+		start:    Point{0, 1, 0},
+		goal:     Point{4, 3, 0},
+		complete: false,
+		end:      &Point{2, 1, 0},
+	},
+	{
+		name:     "no headroom to jump",
+		walker:   walker,
+		world:    testWorld{}.row(0, 4, 0).row(3, 4, 1).row(0, 2, 3),
+		start:    Point{0, 1, 0},
+		goal:     Point{4, 2, 0},
+		complete: false,
+		end:      &Point{2, 1, 0},
+	},
+	{
+		name:     "drop within limit",
+		walker:   walker,
+		world:    testWorld{}.row(0, 2, 3).row(3, 5, 0),
+		start:    Point{0, 4, 0},
+		goal:     Point{5, 1, 0},
+		complete: true,
+		end:      &Point{5, 1, 0},
+	},
+	{
+		name:     "drop too far",
+		walker:   walker,
+		world:    testWorld{}.row(0, 2, 4).row(3, 5, 0),
+		start:    Point{0, 5, 0},
+		goal:     Point{5, 1, 0},
+		complete: false,
+		end:      &Point{2, 5, 0},
+	},
+	{
+		name:     "drop with a longer limit",
+		walker:   Walker{Height: 2, MaxDrop: 4, MaxNodes: 1000},
+		world:    testWorld{}.row(0, 2, 4).row(3, 5, 0),
+		start:    Point{0, 5, 0},
+		goal:     Point{5, 1, 0},
+		complete: true,
+		end:      &Point{5, 1, 0},
+	},
+	{
+		name:     "out of nodes",
+		walker:   Walker{Height: 2, MaxDrop: 3, MaxNodes: 5},
+		world:    testWorld{}.row(0, 20, 0),
+		start:    Point{0, 1, 0},
+		goal:     Point{20, 1, 0},
+		complete: false,
+		end:      &Point{4, 1, 0},
+	},
+}
+
+func TestFind(t *testing.T) {
+	for _, test := range findTests {
+		path, complete := test.walker.Find(test.world.block, test.start, test.goal)
+
+		if complete != test.complete {
+			t.Errorf("%s: complete = %v, want %v", test.name, complete, test.complete)
+		}
+		if test.end == nil {
+			if path != nil {
+				t.Errorf("%s: path = %v, want none", test.name, path)
+			}
+			continue
+		}
+		if len(path) == 0 {
+			t.Errorf("%s: no path, want one ending at %v", test.name, *test.end)
+			continue
+		}
+		if end := path[len(path)-1]; end != *test.end {
+			t.Errorf("%s: path ends at %v, want %v (path %v)", test.name, end, *test.end, path)
+		}
+
+		// Every step moves one block sideways.
+		prev := test.start
+		for _, p := range path {
+			dx, dz := p.X-prev.X, p.Z-prev.Z
+			if dx*dx+dz*dz != 1 {
+				t.Errorf("%s: step from %v to %v is not to a neighbor (path %v)", test.name, prev, p, path)
+				break
+			}
+			prev = p
+		}
+	}
+}
@@ -0,0 +1,459 @@
+// Package permissions decides what each player is allowed to do. Players belong to groups, groups inherit from
+// other groups, and both can be given permission nodes like "stuzzd.command.kick". A node ending in ".*" grants
+// everything under it, "*" grants everything, and a node starting with "-" takes a permission away.
+//
+// Everything is stored in permissions.json, next to stuzzd.conf.
+package permissions
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"github.com/Nightgunner5/stuzzd/config"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const fileName = "permissions.json"
+
+type Group struct {
+	// Groups this group gets permissions, prefix and color from. Earlier groups take priority.
+	Inherits []string `json:",omitempty"`
+
+	// Shown in chat before the names of players in the group.
+	Prefix string `json:",omitempty"`
+
+	// The formatting codes used for the names of players in the group.
+	Color string `json:",omitempty"`
+
+	Permissions []string `json:",omitempty"`
+}
+
+type Player struct {
+	// Earlier groups take priority.
+	Groups []string `json:",omitempty"`
+
+	// Take priority over anything the player gets from their groups.
+	Permissions []string `json:",omitempty"`
+}
+
+type file struct {
+	// The group players who are not in any other group are in.
+	DefaultGroup string
+
+	Groups  map[string]*Group
+	Players map[string]*Player
+}
+
+var (
+	lock sync.RWMutex
+	data file
+)
+
+var ErrNoSuchGroup = errors.New("permissions: no such group")
+
+// Returns true if the named player has the permission node.
+func Has(username, node string) bool {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	if p, ok := data.Players[strings.ToLower(username)]; ok {
+		if allowed, matched := match(p.Permissions, node); matched {
+			return allowed
+		}
+	}
+
+	for _, group := range groupsOf(username) {
+		if allowed, matched := match(data.Groups[group].Permissions, node); matched {
+			return allowed
+		}
+	}
+
+	return false
+}
+
+// Finds the most specific entry matching node. A negated entry beats a granted entry that is just as specific.
+func match(entries []string, node string) (allowed, matched bool) {
+	best := -1
+	for _, entry := range entries {
+		negated := strings.HasPrefix(entry, "-")
+		entry = strings.TrimPrefix(entry, "-")
+
+		var specificity int
+		switch {
+		case entry == node:
+			specificity = len(entry) + 1
+		case entry == "*":
+			specificity = 0
+		case strings.HasSuffix(entry, ".*") && strings.HasPrefix(node, entry[:len(entry)-1]):
+			specificity = len(entry) - 1
+		default:
+			continue
+		}
+
+		if specificity > best || (specificity == best && negated) {
+			best, allowed, matched = specificity, !negated, true
+		}
+	}
+	return
+}
+
+// Returns every group the player is in, directly or by inheritance, in priority order. The caller must hold lock.
+func groupsOf(username string) []string {
+	var direct []string
+	if p, ok := data.Players[strings.ToLower(username)]; ok {
+		direct = p.Groups
+	}
+	if len(direct) == 0 {
+		direct = []string{data.DefaultGroup}
+	}
+
+	var groups []string
+	seen := make(map[string]bool)
+	var visit func(name string)
+	visit = func(name string) {
+		group, ok := data.Groups[name]
+		if !ok || seen[name] {
+			return
+		}
+		seen[name] = true
+		groups = append(groups, name)
+		for _, parent := range group.Inherits {
+			visit(parent)
+		}
+	}
+	for _, name := range direct {
+		visit(name)
+	}
+	return groups
+}
+
+// Returns every group the player is in, directly or by inheritance, in priority order.
+func GroupsOf(username string) []string {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	return groupsOf(username)
+}
+
+// Returns the chat prefix and name color of the player's highest priority group that has them.
+func Format(username string) (prefix, color string) {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	for _, name := range groupsOf(username) {
+		group := data.Groups[name]
+		if prefix == "" {
+			prefix = group.Prefix
+		}
+		if color == "" {
+			color = group.Color
+		}
+	}
+	return
+}
+
+// Returns the names of every group, sorted.
+func Groups() []string {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	names := make([]string, 0, len(data.Groups))
+	for name := range data.Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns false if the player was already directly in the group.
+func AddToGroup(username, group string) (bool, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if _, ok := data.Groups[group]; !ok {
+		return false, ErrNoSuchGroup
+	}
+
+	p := player(username)
+	if contains(p.Groups, group) {
+		return false, nil
+	}
+	p.Groups = append(p.Groups, group)
+	return true, save()
+}
+
+// Returns false if the player was not directly in the group.
+func RemoveFromGroup(username, group string) (bool, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	p := player(username)
+	var removed bool
+	p.Groups, removed = remove(p.Groups, group)
+	if !removed {
+		return false, nil
+	}
+	return true, save()
+}
+
+// Gives the player a node, or takes it away if it starts with "-".
+func SetPlayerPermission(username, node string) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	p := player(username)
+	p.Permissions, _ = remove(p.Permissions, node)
+	p.Permissions, _ = remove(p.Permissions, negate(node))
+	p.Permissions = append(p.Permissions, node)
+	return save()
+}
+
+// Removes a node (granted or negated) from the player. Returns false if the player did not have it.
+func UnsetPlayerPermission(username, node string) (bool, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	p := player(username)
+	var removed, negatedRemoved bool
+	p.Permissions, removed = remove(p.Permissions, node)
+	p.Permissions, negatedRemoved = remove(p.Permissions, negate(node))
+	if !removed && !negatedRemoved {
+		return false, nil
+	}
+	return true, save()
+}
+
+// Gives the group a node, or takes it away if it starts with "-".
+func SetGroupPermission(group, node string) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	g, ok := data.Groups[group]
+	if !ok {
+		return ErrNoSuchGroup
+	}
+	g.Permissions, _ = remove(g.Permissions, node)
+	g.Permissions, _ = remove(g.Permissions, negate(node))
+	g.Permissions = append(g.Permissions, node)
+	return save()
+}
+
+// Removes a node (granted or negated) from the group. Returns false if the group did not have it.
+func UnsetGroupPermission(group, node string) (bool, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	g, ok := data.Groups[group]
+	if !ok {
+		return false, ErrNoSuchGroup
+	}
+	var removed, negatedRemoved bool
+	g.Permissions, removed = remove(g.Permissions, node)
+	g.Permissions, negatedRemoved = remove(g.Permissions, negate(node))
+	if !removed && !negatedRemoved {
+		return false, nil
+	}
+	return true, save()
+}
+
+// Creates an empty group that inherits from the given groups. Returns false if the group already exists.
+func CreateGroup(name string, inherits ...string) (bool, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if _, ok := data.Groups[name]; ok {
+		return false, nil
+	}
+	for _, parent := range inherits {
+		if _, ok := data.Groups[parent]; !ok {
+			return false, ErrNoSuchGroup
+		}
+	}
+	data.Groups[name] = &Group{Inherits: inherits}
+	return true, save()
+}
+
+// Sets the group's chat prefix and name color.
+func SetGroupFormat(name, prefix, color string) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	g, ok := data.Groups[name]
+	if !ok {
+		return ErrNoSuchGroup
+	}
+	g.Prefix, g.Color = prefix, color
+	return save()
+}
+
+// The caller must hold lock for writing.
+func player(username string) *Player {
+	username = strings.ToLower(username)
+	p, ok := data.Players[username]
+	if !ok {
+		p = new(Player)
+		data.Players[username] = p
+	}
+	return p
+}
+
+func negate(node string) string {
+	if strings.HasPrefix(node, "-") {
+		return node[1:]
+	}
+	return "-" + node
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func remove(list []string, s string) ([]string, bool) {
+	for i, item := range list {
+		if item == s {
+			return append(list[:i], list[i+1:]...), true
+		}
+	}
+	return list, false
+}
+
+// The caller must hold lock.
+func save() error {
+	// Players with nothing set don't need to be written.
+	for name, p := range data.Players {
+		if len(p.Groups) == 0 && len(p.Permissions) == 0 {
+			delete(data.Players, name)
+		}
+	}
+
+	out, err := json.MarshalIndent(&data, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(out)
+	return err
+}
+
+func defaults() file {
+	return file{
+		DefaultGroup: "default",
+		Groups: map[string]*Group{
+			"default": {
+				Color: "§6§l",
+			},
+			"op": {
+				Inherits:    []string{"default"},
+				Color:       "§4§l",
+				Permissions: []string{"*"},
+			},
+			// The players in config.Config.Admins start out in this group.
+			"admin": {
+				Inherits: []string{"op"},
+				Color:    "§3§l",
+			},
+		},
+		Players: make(map[string]*Player),
+	}
+}
+
+// True if there was no permissions file when the server started.
+var missing bool
+
+// Writes the permissions file if there wasn't one when the server started. Loading it in init only reads, so
+// importing this package (in tests, for example) doesn't create files.
+func SaveIfMissing() error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if !missing {
+		return nil
+	}
+	if err := save(); err != nil {
+		return err
+	}
+	missing = false
+	return nil
+}
+
+func init() {
+	data = defaults()
+
+	f, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		missing = true
+		for _, admin := range config.Config.Admins {
+			p := player(admin)
+			p.Groups = append(p.Groups, "admin")
+		}
+		migrateOps()
+		return
+	}
+	if err != nil {
+		log.Fatal("While trying to load ", fileName, ": ", err)
+	}
+	defer f.Close()
+
+	data = file{}
+	if err = json.NewDecoder(f).Decode(&data); err != nil {
+		// If the permissions file has errors, don't continue with possibly unwanted operation.
+		log.Fatal("While trying to load ", fileName, ": ", err)
+	}
+	if data.Groups == nil {
+		data.Groups = make(map[string]*Group)
+	}
+	players := make(map[string]*Player, len(data.Players))
+	for name, p := range data.Players {
+		players[strings.ToLower(name)] = p
+	}
+	data.Players = players
+	if _, ok := data.Groups[data.DefaultGroup]; !ok {
+		log.Printf("The default group %q does not exist.", data.DefaultGroup)
+	}
+}
+
+// Puts everyone in ops.txt into the op group.
+func migrateOps() {
+	f, err := os.Open("ops.txt")
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Print("While trying to migrate ops.txt: ", err)
+		}
+		return
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		op, err := r.ReadString('\n')
+		op = strings.TrimSpace(op)
+		if op != "" {
+			p := player(op)
+			p.Groups = append(p.Groups, "op")
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Print("While trying to migrate ops.txt: ", err)
+			return
+		}
+	}
+	log.Print("Moved the players in ops.txt to the op group in ", fileName, ". ops.txt is no longer used.")
+}
@@ -0,0 +1,142 @@
+package player
+
+// Storage slot numbers used in the Inventory list.
+const (
+	SlotHotbarStart int8 = 0
+	SlotHotbarEnd   int8 = 8
+	SlotMainStart   int8 = 9
+	SlotMainEnd     int8 = 35
+	SlotBoots       int8 = 100
+	SlotLeggings    int8 = 101
+	SlotChestplate  int8 = 102
+	SlotHelmet      int8 = 103
+)
+
+// Returns the item in the given storage slot, or nil if the slot is empty.
+// The caller must handle synchronization.
+func (p *Player) GetItem(slot int8) *InventoryItem {
+	for i := range p.Inventory {
+		if p.Inventory[i].Slot == slot {
+			return &p.Inventory[i]
+		}
+	}
+	return nil
+}
+
+// Replaces the contents of the given storage slot. A nil item or an item with no count clears the slot.
+// The caller must handle synchronization.
+func (p *Player) SetItem(slot int8, item *InventoryItem) {
+	for i := range p.Inventory {
+		if p.Inventory[i].Slot == slot {
+			p.Inventory = append(p.Inventory[:i], p.Inventory[i+1:]...)
+			break
+		}
+	}
+	if item == nil || item.Count <= 0 {
+		return
+	}
+	stored := *item
+	stored.Slot = slot
+	p.Inventory = append(p.Inventory, stored)
+}
+
+// Removes up to count items from the given storage slot, clearing the slot if nothing is left.
+// The caller must handle synchronization.
+func (p *Player) RemoveItem(slot int8, count int8) {
+	for i := range p.Inventory {
+		if p.Inventory[i].Slot != slot {
+			continue
+		}
+		if p.Inventory[i].Count > count {
+			p.Inventory[i].Count -= count
+			return
+		}
+		p.Inventory = append(p.Inventory[:i], p.Inventory[i+1:]...)
+		return
+	}
+}
+
+// Reports whether two items can be combined into one stack.
+func (item *InventoryItem) StacksWith(other *InventoryItem) bool {
+	return item.Type == other.Type && item.Damage == other.Damage && item.Meta == nil && other.Meta == nil
+}
+
+func (item *InventoryItem) MaxStack() int8 {
+	return MaxStackSize(item.Type)
+}
+
+// The number of items of a type that fit in one inventory slot.
+func MaxStackSize(itemType int16) int8 {
+	switch {
+	case 256 <= itemType && itemType <= 259, itemType == 261, 267 <= itemType && itemType <= 279,
+		283 <= itemType && itemType <= 286, 290 <= itemType && itemType <= 294,
+		298 <= itemType && itemType <= 317, 2256 <= itemType && itemType <= 2266:
+		// Tools, weapons, armor and records
+		return 1
+	}
+	switch itemType {
+	case 282, 323, 324, 325, 326, 327, 328, 329, 330, 333, 335, 342, 343, 346, 354, 355, 358, 359, 373:
+		return 1
+	case 332, 344, 368:
+		return 16
+	}
+	return 64
+}
+
+// How many half hearts hitting something with an item does. Anything that isn't a weapon or tool does as much as a fist.
+func AttackDamage(itemType int16) int {
+	switch itemType {
+	case 276: // Diamond sword
+		return 7
+	case 267, 279: // Iron sword, diamond axe
+		return 6
+	case 272, 258, 278: // Stone sword, iron axe, diamond pickaxe
+		return 5
+	case 268, 283, 275, 257, 277: // Wood and gold swords, stone axe, iron pickaxe, diamond shovel
+		return 4
+	case 271, 286, 274, 256: // Wood and gold axes, stone pickaxe, iron shovel
+		return 3
+	case 270, 285, 273: // Wood and gold pickaxes, stone shovel
+		return 2
+	}
+	return 1
+}
+
+// Reports whether an item can be worn in the given armor slot.
+func FitsArmorSlot(itemType int16, slot int8) bool {
+	if slot == SlotHelmet && itemType == 86 { // Pumpkin
+		return true
+	}
+	if itemType < 298 || itemType > 317 {
+		return false
+	}
+	return SlotHelmet-int8((itemType-298)%4) == slot
+}
+
+// How many half drumsticks of food eating an item restores and how much saturation that food comes with, as a
+// multiple of the food. ok is false for items that can't be eaten.
+func FoodValue(itemType int16) (food int, saturation float32, ok bool) {
+	switch itemType {
+	case 320, 364: // Cooked porkchop, steak
+		return 8, 0.8, true
+	case 282, 366: // Mushroom stew, cooked chicken
+		return 6, 0.6, true
+	case 297, 350: // Bread, cooked fish
+		return 5, 0.6, true
+	case 322: // Golden apple
+		return 4, 1.2, true
+	case 260: // Apple
+		return 4, 0.3, true
+	case 367: // Rotten flesh
+		return 4, 0.1, true
+	case 319, 363: // Raw porkchop, raw beef
+		return 3, 0.3, true
+	case 375: // Spider eye
+		return 2, 0.8, true
+	case 349, 360, 365: // Raw fish, melon, raw chicken
+		return 2, 0.3, true
+	case 357: // Cookie
+		return 2, 0.1, true
+	}
+	return 0, 0, false
+}
@@ -38,6 +38,11 @@ type Player struct {
 
 	Inventory  []InventoryItem
 	EnderItems []InventoryItem
+
+	// StuzzD-specific fields. Vanilla ignores tags it doesn't know about.
+	StuzzDIgnored    []string  // Lowercase usernames whose chat this player doesn't see.
+	StuzzDMutedUntil int64     // Unix time the player can chat again. 0 if they aren't muted, -1 if they are muted forever.
+	StuzzDHome       []float64 // X, Y, Z, yaw and pitch of the player's /home, or empty if they haven't set one.
 }
 
 type PlayerAbilities struct {
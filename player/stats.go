@@ -0,0 +1,24 @@
+package player
+
+// The values a new (or respawning) player starts with.
+const (
+	MaxHealth          = 20  // Half hearts
+	MaxFood            = 20  // Half drumsticks
+	MaxAir             = 300 // Ticks
+	StartingSaturation = 5
+)
+
+// Puts health, hunger, air, fire and falling back to how they are for a new player.
+// The caller must handle synchronization.
+func (p *Player) ResetStats() {
+	p.Health = MaxHealth
+	p.FoodLevel = MaxFood
+	p.FoodSaturationLevel = StartingSaturation
+	p.FoodExhaustionLevel = 0
+	p.FoodTickTimer = 0
+	p.Air = MaxAir
+	p.Fire = 0
+	p.FallDistance = 0
+	p.DeathTime = 0
+	p.HurtTime = 0
+}
@@ -38,3 +38,25 @@ const (
 	FaceSouth
 	FaceNorth
 )
+
+// Sent as the direction of a block placement when the player is using an item in the air.
+const FaceNone Face = 0xFF
+
+// Returns the offset from a block to its neighbor on this face.
+func (f Face) Offset() (dx, dy, dz int32) {
+	switch f {
+	case FaceDown:
+		return 0, -1, 0
+	case FaceUp:
+		return 0, 1, 0
+	case FaceWest:
+		return 0, 0, -1
+	case FaceEast:
+		return 0, 0, 1
+	case FaceSouth:
+		return -1, 0, 0
+	case FaceNorth:
+		return 1, 0, 0
+	}
+	return 0, 0, 0
+}
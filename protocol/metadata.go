@@ -0,0 +1,56 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Entity metadata, sent with Mob Spawn. Each index holds an int8, int16, int32, float32 or string.
+type Metadata map[uint8]interface{}
+
+// Standard metadata indices shared by every kind of entity.
+const (
+	MetaFlags uint8 = 0 // int8 made of the flags below
+	MetaAir   uint8 = 1 // int16
+)
+
+const (
+	FlagOnFire    int8 = 0x01
+	FlagCrouching int8 = 0x02
+	FlagRiding    int8 = 0x04
+	FlagSprinting int8 = 0x08
+	FlagEating    int8 = 0x10
+)
+
+func (m Metadata) Encode(w io.Writer) {
+	indices := make([]int, 0, len(m))
+	for index := range m {
+		indices = append(indices, int(index))
+	}
+	sort.Ints(indices)
+
+	for _, index := range indices {
+		switch value := m[uint8(index)].(type) {
+		case int8:
+			binary.Write(w, binary.BigEndian, uint8(0<<5|index))
+			binary.Write(w, binary.BigEndian, value)
+		case int16:
+			binary.Write(w, binary.BigEndian, uint8(1<<5|index))
+			binary.Write(w, binary.BigEndian, value)
+		case int32:
+			binary.Write(w, binary.BigEndian, uint8(2<<5|index))
+			binary.Write(w, binary.BigEndian, value)
+		case float32:
+			binary.Write(w, binary.BigEndian, uint8(3<<5|index))
+			binary.Write(w, binary.BigEndian, value)
+		case string:
+			binary.Write(w, binary.BigEndian, uint8(4<<5|index))
+			w.Write(stringToBytes(value))
+		default:
+			panic(fmt.Sprintf("Unhandled metadata type %T at index %d", value, index))
+		}
+	}
+	binary.Write(w, binary.BigEndian, uint8(0x7F))
+}
@@ -7,7 +7,7 @@ import (
 	"fmt"
 	"github.com/Nightgunner5/stuzzd/block"
 	"io"
-	"strings"
+	"math"
 )
 
 // Functions in this file panic instead of returning errors. This allows us to jump straight to the networking goroutine
@@ -76,6 +76,12 @@ func decodeDouble(in io.Reader) float64 {
 	return float64(d) / 32
 }
 
+// Velocities are sent in 1/8000 of a block per tick and the client caps them at 3.9 blocks per tick.
+func encodeVelocity(v float64, out io.Writer) {
+	v = math.Max(-3.9, math.Min(3.9, v))
+	binary.Write(out, binary.BigEndian, int16(v*8000))
+}
+
 func encodeAngle(a float32, out io.Writer) {
 	out.Write([]byte{uint8(a / 180 * 128)})
 }
@@ -114,6 +120,8 @@ type ServerMode int32
 const (
 	Survival ServerMode = 0
 	Creative ServerMode = 1
+	// Like survival, but blocks can't be placed or broken.
+	Adventure ServerMode = 2
 )
 
 type Dimension int32
@@ -209,11 +217,6 @@ func (p Chat) Packet() []byte {
 func ReadChat(in io.Reader) Chat {
 	var p Chat
 	p.Message = bytesToString(in)
-	for _, r := range []rune(p.Message) {
-		if !strings.ContainsRune(" !\"#$%&'()*+,-./0123456789:;<=>?@ABCDEFGHIJKLMNOPQRSTUVWXYZ[\\]^_'abcdefghijklmnopqrstuvwxyz{|}~⌂ÇüéâäàåçêëèïîìÄÅÉæÆôöòûùÿÖÜø£Ø×ƒáíóúñÑªº¿®¬½¼¡«»", r) {
-			panic("Illegal character in string")
-		}
-	}
 	return p
 }
 
@@ -231,6 +234,81 @@ func (p TimeUpdate) Packet() []byte {
 
 // No read function as this is not sent by the client.
 
+// Use Entity (0x07)
+type UseEntity struct {
+	User      int32 // The player's own entity ID
+	Target    int32
+	LeftClick bool // True for attacking, false for interacting
+}
+
+func (p UseEntity) Packet() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint8(0x07))
+	binary.Write(&buf, binary.BigEndian, p.User)
+	binary.Write(&buf, binary.BigEndian, p.Target)
+	binary.Write(&buf, binary.BigEndian, p.LeftClick)
+	return buf.Bytes()
+}
+
+func ReadUseEntity(in io.Reader) UseEntity {
+	var p UseEntity
+	errorCheck(binary.Read(in, binary.BigEndian, &p.User))
+	errorCheck(binary.Read(in, binary.BigEndian, &p.Target))
+	errorCheck(binary.Read(in, binary.BigEndian, &p.LeftClick))
+	return p
+}
+
+// Update Health (0x08)
+type UpdateHealth struct {
+	Health     int16 // 0 to 20, in half hearts. The client shows the death screen at 0 or less.
+	Food       int16 // 0 to 20
+	Saturation float32
+}
+
+func (p UpdateHealth) Packet() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint8(0x08))
+	binary.Write(&buf, binary.BigEndian, p.Health)
+	binary.Write(&buf, binary.BigEndian, p.Food)
+	binary.Write(&buf, binary.BigEndian, p.Saturation)
+	return buf.Bytes()
+}
+
+// No read function as this is not sent by the client.
+
+// Respawn (0x09)
+// Sent by the client when the player clicks the respawn button. The server sends it back to reset the client's world.
+type Respawn struct {
+	Dimension   Dimension
+	Difficulty  Difficulty
+	ServerMode  ServerMode // Sent as a byte
+	WorldHeight int16
+	LevelType   string
+}
+
+func (p Respawn) Packet() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint8(0x09))
+	binary.Write(&buf, binary.BigEndian, p.Dimension)
+	binary.Write(&buf, binary.BigEndian, p.Difficulty)
+	binary.Write(&buf, binary.BigEndian, int8(p.ServerMode))
+	binary.Write(&buf, binary.BigEndian, p.WorldHeight)
+	buf.Write(stringToBytes(p.LevelType))
+	return buf.Bytes()
+}
+
+func ReadRespawn(in io.Reader) Respawn {
+	var p Respawn
+	var mode int8
+	errorCheck(binary.Read(in, binary.BigEndian, &p.Dimension))
+	errorCheck(binary.Read(in, binary.BigEndian, &p.Difficulty))
+	errorCheck(binary.Read(in, binary.BigEndian, &mode))
+	errorCheck(binary.Read(in, binary.BigEndian, &p.WorldHeight))
+	p.LevelType = bytesToString(in)
+	p.ServerMode = ServerMode(mode)
+	return p
+}
+
 // Flying (0x0A)
 type Flying struct {
 	Ground bool
@@ -396,6 +474,59 @@ func ReadPlayerDigging(in io.Reader) PlayerDigging {
 	return p
 }
 
+// Player Block Placement (0x0F)
+// A Direction of FaceNone means the player is using the held item without clicking on a block.
+type PlayerBlockPlacement struct {
+	X         int32
+	Y         uint8
+	Z         int32
+	Direction Face
+	Item      int16 // -1 for an empty hand
+	Count     int8
+	Damage    int16
+	Meta      map[string]interface{}
+}
+
+func (p PlayerBlockPlacement) Packet() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint8(0x0F))
+	binary.Write(&buf, binary.BigEndian, p.X)
+	binary.Write(&buf, binary.BigEndian, p.Y)
+	binary.Write(&buf, binary.BigEndian, p.Z)
+	binary.Write(&buf, binary.BigEndian, p.Direction)
+	WriteSlot(&buf, p.Item, p.Count, p.Damage, p.Meta)
+	return buf.Bytes()
+}
+
+func ReadPlayerBlockPlacement(in io.Reader) PlayerBlockPlacement {
+	var p PlayerBlockPlacement
+	errorCheck(binary.Read(in, binary.BigEndian, &p.X))
+	errorCheck(binary.Read(in, binary.BigEndian, &p.Y))
+	errorCheck(binary.Read(in, binary.BigEndian, &p.Z))
+	errorCheck(binary.Read(in, binary.BigEndian, &p.Direction))
+	p.Item, p.Count, p.Damage, p.Meta = ReadSlot(in)
+	return p
+}
+
+// Held Item Change (0x10)
+// The slot is an index into the hotbar, from 0 to 8.
+type HeldItemChange struct {
+	Slot int16
+}
+
+func (p HeldItemChange) Packet() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint8(0x10))
+	binary.Write(&buf, binary.BigEndian, p.Slot)
+	return buf.Bytes()
+}
+
+func ReadHeldItemChange(in io.Reader) HeldItemChange {
+	var p HeldItemChange
+	errorCheck(binary.Read(in, binary.BigEndian, &p.Slot))
+	return p
+}
+
 // Animation (0x12)
 type Animation struct {
 	EID       int32
@@ -442,6 +573,81 @@ func (p SpawnNamedEntity) Packet() []byte {
 
 // No read function as this is not sent by the client.
 
+// Collect Item (0x16)
+// Plays the animation of an item flying into the collector. The server sends Destroy Entity afterward.
+type CollectItem struct {
+	Collected int32
+	Collector int32
+}
+
+func (p CollectItem) Packet() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint8(0x16))
+	binary.Write(&buf, binary.BigEndian, p.Collected)
+	binary.Write(&buf, binary.BigEndian, p.Collector)
+	return buf.Bytes()
+}
+
+// No read function as this is not sent by the client.
+
+// Mob Spawn (0x18)
+type MobSpawn struct {
+	EID                 int32
+	Type                MobType
+	X, Y, Z             float64
+	Yaw, Pitch, HeadYaw float32
+	Metadata            Metadata
+}
+
+type MobType int8
+
+const (
+	MobPig     MobType = 90
+	MobSheep   MobType = 91
+	MobCow     MobType = 92
+	MobChicken MobType = 93
+
+	MobCreeper  MobType = 50
+	MobSkeleton MobType = 51
+	MobSpider   MobType = 52
+	MobZombie   MobType = 54
+)
+
+func (p MobSpawn) Packet() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint8(0x18))
+	binary.Write(&buf, binary.BigEndian, p.EID)
+	binary.Write(&buf, binary.BigEndian, p.Type)
+	encodeDouble(p.X, &buf)
+	encodeDouble(p.Y, &buf)
+	encodeDouble(p.Z, &buf)
+	encodeAngle(p.Yaw, &buf)
+	encodeAngle(p.Pitch, &buf)
+	encodeAngle(p.HeadYaw, &buf)
+	p.Metadata.Encode(&buf)
+	return buf.Bytes()
+}
+
+// No read function as this is not sent by the client.
+
+// Entity Velocity (0x1C)
+type EntityVelocity struct {
+	ID         int32
+	VX, VY, VZ float64 // Blocks per tick
+}
+
+func (p EntityVelocity) Packet() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint8(0x1C))
+	binary.Write(&buf, binary.BigEndian, p.ID)
+	encodeVelocity(p.VX, &buf)
+	encodeVelocity(p.VY, &buf)
+	encodeVelocity(p.VZ, &buf)
+	return buf.Bytes()
+}
+
+// No read function as this is not sent by the client.
+
 // Destroy Entity (0x1D)
 type DestroyEntity struct {
 	ID int32
@@ -528,6 +734,46 @@ func (p EntityHeadLook) Packet() []byte {
 
 // No read function as this is not sent by the client.
 
+// Entity Status (0x26)
+type EntityStatus struct {
+	ID     int32
+	Status EntityStatusType
+}
+
+type EntityStatusType int8
+
+const (
+	EntityHurt EntityStatusType = 2
+	EntityDead EntityStatusType = 3
+	EntityAte  EntityStatusType = 9 // Only sent to the player who finished eating
+)
+
+func (p EntityStatus) Packet() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint8(0x26))
+	binary.Write(&buf, binary.BigEndian, p.ID)
+	binary.Write(&buf, binary.BigEndian, p.Status)
+	return buf.Bytes()
+}
+
+// No read function as this is not sent by the client.
+
+// Entity Metadata (0x28)
+type EntityMetadata struct {
+	ID       int32
+	Metadata Metadata
+}
+
+func (p EntityMetadata) Packet() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint8(0x28))
+	binary.Write(&buf, binary.BigEndian, p.ID)
+	p.Metadata.Encode(&buf)
+	return buf.Bytes()
+}
+
+// No read function as this is not sent by the client.
+
 // Chunk Allocation (0x32)
 type ChunkAllocation struct {
 	X, Z int32
@@ -613,6 +859,181 @@ func (p BlockChange) Packet() []byte {
 
 // No read function as this is not sent by the client.
 
+// Explosion (0x3C)
+// Plays the explosion effect. Each destroyed block is sent as an offset from the center.
+type Explosion struct {
+	X, Y, Z   float64
+	Radius    float32
+	Destroyed [][3]int8
+}
+
+func (p Explosion) Packet() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint8(0x3C))
+	binary.Write(&buf, binary.BigEndian, p.X)
+	binary.Write(&buf, binary.BigEndian, p.Y)
+	binary.Write(&buf, binary.BigEndian, p.Z)
+	binary.Write(&buf, binary.BigEndian, p.Radius)
+	binary.Write(&buf, binary.BigEndian, int32(len(p.Destroyed)))
+	for _, offset := range p.Destroyed {
+		binary.Write(&buf, binary.BigEndian, offset)
+	}
+	return buf.Bytes()
+}
+
+// No read function as this is not sent by the client.
+
+// Close Window (0x65)
+type CloseWindow struct {
+	WindowID int8
+}
+
+func (p CloseWindow) Packet() []byte {
+	return []byte{0x65, byte(p.WindowID)}
+}
+
+func ReadCloseWindow(in io.Reader) CloseWindow {
+	var p CloseWindow
+	errorCheck(binary.Read(in, binary.BigEndian, &p.WindowID))
+	return p
+}
+
+// Window Click (0x66)
+// Item is what the client thinks was in the slot before it was clicked.
+type WindowClick struct {
+	WindowID   int8
+	Slot       int16 // -999 for clicking outside the window
+	RightClick bool
+	Action     int16
+	Shift      bool
+	Item       Slot
+}
+
+func (p WindowClick) Packet() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint8(0x66))
+	binary.Write(&buf, binary.BigEndian, p.WindowID)
+	binary.Write(&buf, binary.BigEndian, p.Slot)
+	var right, shift uint8
+	if p.RightClick {
+		right = 1
+	}
+	if p.Shift {
+		shift = 1
+	}
+	binary.Write(&buf, binary.BigEndian, right)
+	binary.Write(&buf, binary.BigEndian, p.Action)
+	binary.Write(&buf, binary.BigEndian, shift)
+	p.Item.write(&buf)
+	return buf.Bytes()
+}
+
+func ReadWindowClick(in io.Reader) WindowClick {
+	var p WindowClick
+	var right, shift uint8
+	errorCheck(binary.Read(in, binary.BigEndian, &p.WindowID))
+	errorCheck(binary.Read(in, binary.BigEndian, &p.Slot))
+	errorCheck(binary.Read(in, binary.BigEndian, &right))
+	errorCheck(binary.Read(in, binary.BigEndian, &p.Action))
+	errorCheck(binary.Read(in, binary.BigEndian, &shift))
+	p.RightClick = right == 1
+	p.Shift = shift == 1
+	p.Item = readSlot(in)
+	return p
+}
+
+// Set Slot (0x67)
+// A window ID and slot of -1 sets the item held on the cursor.
+type SetSlot struct {
+	WindowID int8
+	Slot     int16
+	Item     Slot
+}
+
+func (p SetSlot) Packet() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint8(0x67))
+	binary.Write(&buf, binary.BigEndian, p.WindowID)
+	binary.Write(&buf, binary.BigEndian, p.Slot)
+	p.Item.write(&buf)
+	return buf.Bytes()
+}
+
+// No read function as this is not sent by the client.
+
+// Window Items (0x68)
+type WindowItems struct {
+	WindowID int8
+	Items    []Slot
+}
+
+func (p WindowItems) Packet() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint8(0x68))
+	binary.Write(&buf, binary.BigEndian, p.WindowID)
+	binary.Write(&buf, binary.BigEndian, int16(len(p.Items)))
+	for _, item := range p.Items {
+		item.write(&buf)
+	}
+	return buf.Bytes()
+}
+
+// No read function as this is not sent by the client.
+
+// Confirm Transaction (0x6A)
+// Sent by the server to accept or reject a window click. If a click is rejected, the client sends this
+// packet back to acknowledge it.
+type Transaction struct {
+	WindowID int8
+	Action   int16
+	Accepted bool
+}
+
+func (p Transaction) Packet() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint8(0x6A))
+	binary.Write(&buf, binary.BigEndian, p.WindowID)
+	binary.Write(&buf, binary.BigEndian, p.Action)
+	var accepted uint8
+	if p.Accepted {
+		accepted = 1
+	}
+	binary.Write(&buf, binary.BigEndian, accepted)
+	return buf.Bytes()
+}
+
+func ReadTransaction(in io.Reader) Transaction {
+	var p Transaction
+	var accepted uint8
+	errorCheck(binary.Read(in, binary.BigEndian, &p.WindowID))
+	errorCheck(binary.Read(in, binary.BigEndian, &p.Action))
+	errorCheck(binary.Read(in, binary.BigEndian, &accepted))
+	p.Accepted = accepted == 1
+	return p
+}
+
+// Creative Inventory Action (0x6B)
+// A slot of -1 means the item is being dropped.
+type CreativeInventoryAction struct {
+	Slot int16
+	Item Slot
+}
+
+func (p CreativeInventoryAction) Packet() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint8(0x6B))
+	binary.Write(&buf, binary.BigEndian, p.Slot)
+	p.Item.write(&buf)
+	return buf.Bytes()
+}
+
+func ReadCreativeInventoryAction(in io.Reader) CreativeInventoryAction {
+	var p CreativeInventoryAction
+	errorCheck(binary.Read(in, binary.BigEndian, &p.Slot))
+	p.Item = readSlot(in)
+	return p
+}
+
 type GameStateType byte
 
 const (
@@ -635,6 +1056,25 @@ func (p ChangeGameState) Packet() []byte {
 
 // No read function as this is not sent by the client.
 
+// Thunderbolt (0x47)
+type Thunderbolt struct {
+	ID      int32
+	X, Y, Z float64
+}
+
+func (p Thunderbolt) Packet() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint8(0x47))
+	binary.Write(&buf, binary.BigEndian, p.ID)
+	binary.Write(&buf, binary.BigEndian, true) // Always true
+	encodeDouble(p.X, &buf)
+	encodeDouble(p.Y, &buf)
+	encodeDouble(p.Z, &buf)
+	return buf.Bytes()
+}
+
+// No read function as this is not sent by the client. (The client sends 0x47 when it is actually an HTTP request.)
+
 // Player List Item (0xC9)
 type PlayerListItem struct {
 	Name   string
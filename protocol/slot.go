@@ -1,11 +1,27 @@
 package protocol
 
 import (
+	"bytes"
 	"encoding/binary"
 	"github.com/Nightgunner5/go.nbt"
 	"io"
 )
 
+// An item stack as sent in window packets. An ID of -1 is an empty slot.
+type Slot struct {
+	ID     int16
+	Count  int8
+	Damage int16
+	Meta   map[string]interface{}
+}
+
+var EmptySlot = Slot{ID: -1}
+
+// Items that can be enchanted have an extra NBT field in their slot data.
+func hasSlotMeta(id int16) bool {
+	return 256 <= id && id <= 259 || 267 <= id && id <= 279 || 283 <= id && id <= 286 || 290 <= id && id <= 294 || 298 <= id && id <= 317 || id == 261 || id == 359 || id == 346
+}
+
 func ReadSlot(in io.Reader) (id int16, count int8, damage int16, meta map[string]interface{}) {
 	binary.Read(in, binary.BigEndian, &id)
 	if id == -1 {
@@ -15,8 +31,8 @@ func ReadSlot(in io.Reader) (id int16, count int8, damage int16, meta map[string
 	binary.Read(in, binary.BigEndian, &count)
 	binary.Read(in, binary.BigEndian, &damage)
 
-	if 256 <= id && id <= 259 || 267 <= id && id <= 279 || 283 <= id && id <= 286 || 290 <= id && id <= 294 || 298 <= id && id <= 317 || id == 261 || id == 359 || id == 346 {
-		var more int8
+	if hasSlotMeta(id) {
+		var more int16
 		binary.Read(in, binary.BigEndian, &more)
 		if more > -1 {
 			nbt.Unmarshal(nbt.GZip, &io.LimitedReader{R: in, N: int64(more)}, &meta)
@@ -24,3 +40,34 @@ func ReadSlot(in io.Reader) (id int16, count int8, damage int16, meta map[string
 	}
 	return
 }
+
+func readSlot(in io.Reader) Slot {
+	var s Slot
+	s.ID, s.Count, s.Damage, s.Meta = ReadSlot(in)
+	return s
+}
+
+func WriteSlot(out io.Writer, id int16, count int8, damage int16, meta map[string]interface{}) {
+	binary.Write(out, binary.BigEndian, id)
+	if id == -1 {
+		return
+	}
+
+	binary.Write(out, binary.BigEndian, count)
+	binary.Write(out, binary.BigEndian, damage)
+
+	if hasSlotMeta(id) {
+		if meta == nil {
+			binary.Write(out, binary.BigEndian, int16(-1))
+			return
+		}
+		var buf bytes.Buffer
+		nbt.Marshal(nbt.GZip, &buf, meta)
+		binary.Write(out, binary.BigEndian, int16(buf.Len()))
+		out.Write(buf.Bytes())
+	}
+}
+
+func (s Slot) write(out io.Writer) {
+	WriteSlot(out, s.ID, s.Count, s.Damage, s.Meta)
+}
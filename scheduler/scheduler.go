@@ -0,0 +1,134 @@
+// Package scheduler runs the server's game loop. Subsystems register tasks that run every tick or every few
+// ticks, and the loop keeps the tick rate steady, catching up on missed ticks or skipping them when the server
+// falls too far behind.
+package scheduler
+
+import (
+	"github.com/Nightgunner5/stuzzd/config"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const Tick = time.Second / 20
+
+type task struct {
+	name     string
+	interval uint64
+	run      func()
+}
+
+var tasks []task
+var taskLock sync.Mutex
+
+// Registers a task that runs on every tick that is a multiple of interval.
+// Tasks run one at a time on the scheduler's goroutine, in the order they were registered, so they should hand
+// anything that might block (such as sending packets) off to another goroutine.
+func Every(interval uint64, name string, run func()) {
+	if interval == 0 {
+		panic("scheduler: zero interval for task " + name)
+	}
+
+	taskLock.Lock()
+	defer taskLock.Unlock()
+
+	tasks = append(tasks, task{name: name, interval: interval, run: run})
+}
+
+// Registers a task that runs once per tick.
+func EveryTick(name string, run func()) {
+	Every(1, name, run)
+}
+
+var stop = make(chan struct{})
+var stopOnce sync.Once
+
+// Makes Run return after the current tick finishes.
+func Stop() {
+	stopOnce.Do(func() {
+		close(stop)
+	})
+}
+
+var averageLock sync.Mutex
+var average time.Duration
+
+// Returns a moving average of how long each tick takes to run.
+func TickDuration() time.Duration {
+	averageLock.Lock()
+	defer averageLock.Unlock()
+
+	return average
+}
+
+// Runs the game loop until Stop is called.
+func Run() {
+	next := time.Now()
+	for {
+		if wait := next.Sub(time.Now()); wait > 0 {
+			select {
+			case <-stop:
+				return
+			case <-time.After(wait):
+			}
+		} else {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			if behind := uint64(-wait / Tick); behind > config.Config.MaxCatchUpTicks {
+				log.Printf("Can't keep up! Skipping %d ticks.", behind)
+				next = next.Add(time.Duration(behind) * Tick)
+			}
+		}
+
+		runTick()
+		next = next.Add(Tick)
+	}
+}
+
+func runTick() {
+	taskLock.Lock()
+	current := tasks
+	taskLock.Unlock()
+
+	atomic.AddUint64(&config.Tick, 1)
+
+	start := time.Now()
+	var slowest string
+	var slowestTime time.Duration
+	for _, t := range current {
+		if config.Tick%t.interval != 0 {
+			continue
+		}
+
+		taskStart := time.Now()
+		runTask(t)
+		if taken := time.Since(taskStart); taken > slowestTime {
+			slowest, slowestTime = t.name, taken
+		}
+	}
+	taken := time.Since(start)
+
+	averageLock.Lock()
+	average = (average*19 + taken) / 20
+	averageLock.Unlock()
+
+	if taken > Tick {
+		log.Printf("Tick %d took %v, %v longer than it should have. The slowest task was %s (%v).", config.Tick, taken, taken-Tick, slowest, slowestTime)
+	}
+}
+
+// A task that panics is logged and skipped instead of stopping the whole server.
+func runTask(t task) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("Task %s panicked: %v", t.name, err)
+		}
+	}()
+
+	t.run()
+}
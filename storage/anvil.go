@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"github.com/Nightgunner5/go.nbt"
 	"github.com/Nightgunner5/stuzzd/chunk"
+	"io"
+	"io/ioutil"
 	"os"
 	"sync"
 )
@@ -72,119 +74,83 @@ func ReadChunk(chunkX, chunkZ int32) (*chunk.Chunk, error) {
 	return chunk.Level, err
 }
 
+// Writes a chunk into its region file. The whole region file is rewritten through a temporary file, so a failed
+// save can't leave it half-written.
 func WriteChunk(chunk *chunk.Chunk) error {
-	if !chunk.NeedsSave {
-		return nil
+	var buf bytes.Buffer
+	changed, err := chunk.MarshalIfChanged(func() error {
+		return nbt.Marshal(nbt.ZLib, &buf, ChunkHolder{chunk})
+	})
+	if !changed || err != nil {
+		return err
 	}
 
 	lock := getLock(chunk.X, chunk.Z)
 	lock.Lock()
 	defer lock.Unlock()
 
-	chunk.NeedsSave = false
-
 	regionX, regionZ := chunk.X>>5, chunk.Z>>5
 	chunkX, chunkZ := chunk.X&0x1F, chunk.Z&0x1F
 
-	f, err := os.OpenFile(fmt.Sprintf("world/region/r.%d.%d.mca", regionX, regionZ), os.O_CREATE|os.O_RDWR, 0666)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
+	encoded := append([]byte{0, 0, 0, 0, byte(nbt.ZLib)}, buf.Bytes()...)
 
-	fstat, err := f.Stat()
-	if err != nil {
+	binary.BigEndian.PutUint32(encoded[:4], uint32(len(encoded)-4))
+
+	numSectors := len(encoded)/4096 + 1
+
+	name := fmt.Sprintf("world/region/r.%d.%d.mca", regionX, regionZ)
+	region, err := ioutil.ReadFile(name)
+	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
-	size := fstat.Size()
-	if size%4096 != 0 {
-		needed := 4096 - (size % 4096)
-		f.Seek(0, os.SEEK_END)
-		f.Write(make([]byte, needed))
-		size += needed
-		f.Seek(0, os.SEEK_SET)
+	// Whole sectors only, starting with the two header sectors.
+	if len(region)%4096 != 0 {
+		region = append(region, make([]byte, 4096-len(region)%4096)...)
+	}
+	if len(region) < 8192 {
+		region = append(region, make([]byte, 8192-len(region))...)
 	}
 
-	sectors := make([]bool, size>>12)
-	if len(sectors) < 2 {
-		sectors = make([]bool, 2)
-		f.Write(make([]byte, 8192))
-	} else {
-		sectors[0] = true
-		sectors[1] = true
-
-		for i := 0; i < 1024; i++ {
-			var location uint32
-			binary.Read(f, binary.BigEndian, &location)
-			if location == 0 {
-				continue
-			}
-
-			firstSector := location >> 8
-			numSectors := location & 0xFF
-
-			for sector := firstSector; sector < firstSector+numSectors; sector++ {
-				sectors[sector] = true
-			}
+	header := int(chunkZ<<5|chunkX) << 2
+	sectors := make([]bool, len(region)>>12)
+	sectors[0] = true
+	sectors[1] = true
+	for i := 0; i < 4096; i += 4 {
+		if i == header {
+			continue // This chunk's old sectors can be reused.
 		}
-
-		f.Seek(int64(chunkZ<<5|chunkX)<<2, os.SEEK_SET)
-		var location uint32
-		binary.Read(f, binary.BigEndian, &location)
-		firstSector := location >> 8
-		numSectors := location & 0xFF
-		for sector := firstSector; sector < firstSector+numSectors; sector++ {
-			sectors[sector] = false
+		location := binary.BigEndian.Uint32(region[i:])
+		firstSector := int(location >> 8)
+		for sector := firstSector; sector < firstSector+int(location&0xFF) && sector < len(sectors); sector++ {
+			sectors[sector] = true
 		}
 	}
 
-	var buf bytes.Buffer
-	err = nbt.Marshal(nbt.ZLib, &buf, ChunkHolder{chunk})
-	if err != nil {
-		return err
-	}
-
-	encoded := append([]byte{0, 0, 0, 0, byte(nbt.ZLib)}, buf.Bytes()...)
-
-	binary.BigEndian.PutUint32(encoded[:4], uint32(len(encoded)-4))
-
-	numSectors := len(encoded)/4096 + 1
-
+	// Use the first gap that is big enough, or add the chunk to the end of the file.
+	firstSector := len(sectors)
 search:
-	for firstSector := 2; firstSector+numSectors < len(sectors); firstSector++ {
-		if sectors[firstSector] {
-			continue search
-		}
-
-		for availableSectors := 1; availableSectors < numSectors; availableSectors++ {
-			if sectors[firstSector+availableSectors] {
-				firstSector += availableSectors
+	for start := 2; start+numSectors <= len(sectors); start++ {
+		for sector := start; sector < start+numSectors; sector++ {
+			if sectors[sector] {
+				start = sector
 				continue search
 			}
 		}
-
-		f.Seek(int64(chunkZ<<5|chunkX)<<2, os.SEEK_SET)
-		binary.Write(f, binary.BigEndian, uint32(firstSector<<8)|uint32(numSectors))
-		f.Seek(int64((chunkZ<<5|chunkX)<<2)+4096, os.SEEK_SET)
-		binary.Write(f, binary.BigEndian, uint32(chunk.LastUpdate))
-
-		f.Seek(int64(firstSector)<<12, os.SEEK_SET)
-		_, err = f.Write(encoded)
-
-		return err
+		firstSector = start
+		break
 	}
 
-	f.Seek(int64(chunkZ<<5|chunkX)<<2, os.SEEK_SET)
-	binary.Write(f, binary.BigEndian, uint32(len(sectors)<<8)|uint32(numSectors))
-	f.Seek(int64((chunkZ<<5|chunkX)<<2)+4096, os.SEEK_SET)
-	binary.Write(f, binary.BigEndian, uint32(chunk.LastUpdate))
-
-	f.Seek(0, os.SEEK_END)
-	_, err = f.Write(encoded)
-
-	// File length is fixed on the next write.
+	if end := (firstSector + numSectors) << 12; end > len(region) {
+		region = append(region, make([]byte, end-len(region))...)
+	}
+	copy(region[firstSector<<12:], encoded)
+	binary.BigEndian.PutUint32(region[header:], uint32(firstSector<<8)|uint32(numSectors))
+	binary.BigEndian.PutUint32(region[header+4096:], uint32(chunk.LastUpdate))
 
-	return err
+	return replaceFile(name, func(w io.Writer) error {
+		_, err := w.Write(region)
+		return err
+	})
 }
 
 type ChunkHolder struct {
@@ -3,10 +3,10 @@ package storage
 import (
 	"fmt"
 	"github.com/Nightgunner5/stuzzd/chunk"
+	"github.com/Nightgunner5/stuzzd/scheduler"
 	"log"
 	"runtime"
 	"sync"
-	"time"
 )
 
 func loadChunk(chunkX, chunkZ int32) *chunk.Chunk {
@@ -14,6 +14,7 @@ func loadChunk(chunkX, chunkZ int32) *chunk.Chunk {
 	if err != nil {
 		return ChunkGen(chunkX, chunkZ)
 	}
+	chunk.AssignEntityIDs()
 	return chunk
 }
 
@@ -60,6 +61,23 @@ func GetChunk(x, z int32) *chunk.Chunk {
 	return chunks[id]
 }
 
+// Returns the chunk at x, z if it is already loaded, or nil without loading it if it isn't. A chunk that is
+// returned is acquired as if by GetChunk, so the caller must release it with ReleaseChunk.
+func LoadedChunk(x, z int32) *chunk.Chunk {
+	id := uint64(uint32(x))<<32 | uint64(uint32(z))
+	chunkLock.RLock()
+	defer chunkLock.RUnlock()
+
+	chunk, ok := chunks[id]
+	if !ok {
+		return nil
+	}
+	userLock.Lock()
+	users[id]++
+	userLock.Unlock()
+	return chunk
+}
+
 func ReleaseChunk(x, z int32) {
 	userLock.Lock()
 	defer userLock.Unlock()
@@ -74,60 +92,74 @@ func ReleaseChunkContaining(x, z int32) {
 	ReleaseChunk(x>>4, z>>4)
 }
 
-func init() {
-	go chunkRecycler()
-}
-
-func chunkRecycler() {
-	for {
-		time.Sleep(15 * time.Second)
+// Returns every chunk that is currently loaded. Each chunk is acquired as if by GetChunk, so the caller must
+// release them with ReleaseChunk.
+func LoadedChunks() []*chunk.Chunk {
+	chunkLock.RLock()
+	defer chunkLock.RUnlock()
 
-		chunkLock.Lock()
-		toSave := make([]*chunk.Chunk, 0, len(chunks))
+	userLock.Lock()
+	defer userLock.Unlock()
 
-		userLock.Lock()
-		for id, chunk := range chunks {
-			toSave = append(toSave, chunk)
-			if users[id] == 0 {
-				delete(chunks, id)
-			}
-		}
-		userLock.Unlock()
-		chunkLock.Unlock()
-
-		for _, chunk := range toSave {
-			go func() {
-				err := WriteChunk(chunk)
-				if err != nil {
-					log.Print(err)
-				}
-			}()
-		}
+	loaded := make([]*chunk.Chunk, 0, len(chunks))
+	for id, chunk := range chunks {
+		users[id]++
+		loaded = append(loaded, chunk)
 	}
+	return loaded
 }
 
-func SaveAndUnloadAllChunks() {
+func init() {
+	scheduler.Every(15*20, "chunk recycler", recycleChunks)
+}
+
+// Saves every loaded chunk and unloads the ones nothing is using.
+func recycleChunks() {
 	chunkLock.Lock()
-	defer chunkLock.Unlock()
+	toSave := make([]*chunk.Chunk, 0, len(chunks))
 
 	userLock.Lock()
-	defer userLock.Unlock()
-
-	users = make(map[uint64]uint64) // Almost definitely faster than looping through it.
+	for id, chunk := range chunks {
+		toSave = append(toSave, chunk)
+		if users[id] == 0 {
+			delete(chunks, id)
+		}
+	}
+	userLock.Unlock()
+	chunkLock.Unlock()
 
-	var wg sync.WaitGroup
-	for _, chunk := range chunks {
-		wg.Add(1)
+	for _, chunk := range toSave {
 		go func() {
 			err := WriteChunk(chunk)
 			if err != nil {
 				log.Print(err)
 			}
-			wg.Done()
 		}()
 	}
+}
+
+// Writes every loaded chunk that has changed since it was last saved. The chunks stay loaded. Returns an error
+// for each chunk that could not be written.
+func SaveAllChunks() []error {
+	chunkLock.RLock()
+	defer chunkLock.RUnlock()
 
-	chunks = make(map[uint64]*chunk.Chunk)
+	var errLock sync.Mutex
+	var errs []error
+
+	var wg sync.WaitGroup
+	for _, c := range chunks {
+		wg.Add(1)
+		go func(c *chunk.Chunk) {
+			defer wg.Done()
+			if err := WriteChunk(c); err != nil {
+				errLock.Lock()
+				errs = append(errs, fmt.Errorf("chunk %d, %d: %v", c.X, c.Z, err))
+				errLock.Unlock()
+			}
+		}(c)
+	}
+	wg.Wait()
 
-	wg.Wait() // Don't return until all the chunks are written or the server could stop while writing a chunk or before all chunks are written.
+	return errs
 }
@@ -0,0 +1,24 @@
+package storage
+
+import (
+	"io"
+	"os"
+)
+
+// Writes a file by writing name.tmp and moving it into place, so a failed or interrupted save can't leave a
+// half-written file behind.
+func replaceFile(name string, write func(io.Writer) error) error {
+	f, err := os.Create(name + ".tmp")
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name + ".tmp")
+		return err
+	}
+	return os.Rename(name+".tmp", name)
+}
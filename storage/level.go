@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"errors"
+	"github.com/Nightgunner5/go.nbt"
+	"github.com/Nightgunner5/stuzzd/scheduler"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+// The parts of world/level.dat that StuzzD uses. Everything else in level.dat, like the seed and the world's
+// name, is kept as it was read and written back untouched.
+type Level struct {
+	SpawnX int32
+	SpawnY int32
+	SpawnZ int32
+
+	Time    int64 // How many ticks the world has run for
+	DayTime int64 // The time of day in ticks. 0 is sunrise and a day is 24000 ticks.
+
+	Weather
+}
+
+type Weather struct {
+	Raining     bool
+	RainTime    int32 // Ticks until Raining changes
+	Thundering  bool
+	ThunderTime int32 // Ticks until Thundering changes
+}
+
+type levelHolder struct {
+	Data map[string]interface{}
+}
+
+var (
+	level       Level
+	levelData   map[string]interface{} // Every tag in level.dat, including the ones StuzzD doesn't use
+	levelLock   sync.Mutex
+	levelLoaded bool
+	levelBroken bool // level.dat exists but couldn't be read, so it must not be overwritten
+)
+
+var errLevelBroken = errors.New("level.dat could not be read when the server started, so it was not overwritten")
+
+func init() {
+	scheduler.Every(60*20, "level saver", func() {
+		if err := SaveLevel(); err != nil {
+			log.Print("While trying to save level.dat: ", err)
+		}
+	})
+}
+
+// Reads level.dat the first time it is needed. If there isn't one, the spawn is put on the surface near the
+// middle of the world. The caller must hold levelLock.
+func loadLevel() {
+	if levelLoaded {
+		return
+	}
+	levelLoaded = true
+
+	f, err := os.Open("world/level.dat")
+	if err == nil {
+		defer f.Close()
+
+		var holder levelHolder
+		if err = nbt.Unmarshal(nbt.GZip, f, &holder); err == nil && holder.Data != nil {
+			levelData = holder.Data
+			level.SpawnX = int32(intTag("SpawnX"))
+			level.SpawnY = int32(intTag("SpawnY"))
+			level.SpawnZ = int32(intTag("SpawnZ"))
+			level.Time = intTag("Time")
+			level.DayTime = intTag("DayTime")
+			level.Raining = intTag("raining") != 0
+			level.RainTime = int32(intTag("rainTime"))
+			level.Thundering = intTag("thundering") != 0
+			level.ThunderTime = int32(intTag("thunderTime"))
+			return
+		}
+		if err == nil {
+			err = errors.New("no Data compound")
+		}
+	}
+	if !os.IsNotExist(err) {
+		log.Print("While trying to load level.dat: ", err, ". It won't be saved until the server is restarted.")
+		levelBroken = true
+	}
+	levelData = make(map[string]interface{})
+
+	spawnChunk := GetChunk(0, 0)
+	level.SpawnX, level.SpawnY, level.SpawnZ = 8, spawnChunk.GetHighestBlockYAt(8, 8)+1, 8
+	ReleaseChunk(0, 0)
+}
+
+// Returns the block players spawn in.
+func Spawn() (x, y, z int32) {
+	levelLock.Lock()
+	defer levelLock.Unlock()
+
+	loadLevel()
+	return level.SpawnX, level.SpawnY, level.SpawnZ
+}
+
+// Moves the world spawn and saves level.dat.
+func SetSpawn(x, y, z int32) error {
+	levelLock.Lock()
+	defer levelLock.Unlock()
+
+	loadLevel()
+	level.SpawnX, level.SpawnY, level.SpawnZ = x, y, z
+	return saveLevel()
+}
+
+// Returns the age of the world and the time of day, in ticks.
+func WorldTime() (age, dayTime int64) {
+	levelLock.Lock()
+	defer levelLock.Unlock()
+
+	loadLevel()
+	return level.Time, level.DayTime
+}
+
+// Moves the world forward a tick. The time of day only changes if daylight is true.
+func AdvanceTime(daylight bool) {
+	levelLock.Lock()
+	defer levelLock.Unlock()
+
+	loadLevel()
+	level.Time++
+	if daylight {
+		level.DayTime++
+	}
+}
+
+// Sets the time of day. It will be saved with the rest of level.dat.
+func SetDayTime(dayTime int64) {
+	levelLock.Lock()
+	defer levelLock.Unlock()
+
+	loadLevel()
+	level.DayTime = dayTime
+}
+
+func GetWeather() Weather {
+	levelLock.Lock()
+	defer levelLock.Unlock()
+
+	loadLevel()
+	return level.Weather
+}
+
+// Changes the weather without anything else changing it in between. It will be saved with the rest of
+// level.dat.
+func UpdateWeather(update func(*Weather)) {
+	levelLock.Lock()
+	defer levelLock.Unlock()
+
+	loadLevel()
+	update(&level.Weather)
+}
+
+func SaveLevel() error {
+	levelLock.Lock()
+	defer levelLock.Unlock()
+
+	loadLevel()
+	return saveLevel()
+}
+
+// The caller must hold levelLock.
+func saveLevel() error {
+	if levelBroken {
+		return errLevelBroken
+	}
+
+	levelData["SpawnX"] = level.SpawnX
+	levelData["SpawnY"] = level.SpawnY
+	levelData["SpawnZ"] = level.SpawnZ
+	levelData["Time"] = level.Time
+	levelData["DayTime"] = level.DayTime
+	levelData["raining"] = byteTag(level.Raining)
+	levelData["rainTime"] = level.RainTime
+	levelData["thundering"] = byteTag(level.Thundering)
+	levelData["thunderTime"] = level.ThunderTime
+
+	return replaceFile("world/level.dat", func(w io.Writer) error {
+		return nbt.Marshal(nbt.GZip, w, levelHolder{levelData})
+	})
+}
+
+// Reads a whole number tag from level.dat, whatever its size. Missing tags are 0.
+// The caller must hold levelLock.
+func intTag(name string) int64 {
+	switch v := levelData[name].(type) {
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	}
+	return 0
+}
+
+func byteTag(b bool) int8 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -2,8 +2,9 @@ package storage
 
 import (
 	"github.com/Nightgunner5/go.nbt"
+	"github.com/Nightgunner5/stuzzd/config"
 	"github.com/Nightgunner5/stuzzd/player"
-	"log"
+	"io"
 	"os"
 	"sync"
 )
@@ -32,11 +33,12 @@ func loadPlayer(name string) *player.Player {
 
 	f, err := os.Open("world/players/" + name + ".dat")
 	if err != nil {
-		spawnChunk := GetChunk(0, 0)
-		player.Position = []float64{8.5, float64(spawnChunk.GetHighestBlockYAt(8, 8)) + 1, 8.5}
+		x, y, z := Spawn()
+		player.Position = []float64{float64(x) + 0.5, float64(y), float64(z) + 0.5}
 		player.Motion = []float64{0, 0, 0}
 		player.Rotation = []float32{0, 0}
-		ReleaseChunk(0, 0)
+		player.ResetStats()
+		player.GameType = uint32(config.Config.DefaultGameMode)
 		return player
 	}
 	defer f.Close()
@@ -66,31 +68,7 @@ func SaveAndUnloadPlayer(name string, player *player.Player) error {
 }
 
 func savePlayer(name string, player *player.Player) error {
-	f, err := os.Create("world/players/" + name + ".dat")
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	err = nbt.Marshal(nbt.GZip, f, player)
-
-	return err
-}
-
-func SaveAllPlayers() {
-	playerLock.Lock()
-	defer playerLock.Unlock()
-
-	var wg sync.WaitGroup
-	for name, player := range players {
-		wg.Add(1)
-		go func() {
-			err := savePlayer(name, player)
-			if err != nil {
-				log.Print(err)
-			}
-			wg.Done()
-		}()
-	}
-	wg.Wait()
+	return replaceFile("world/players/"+name+".dat", func(w io.Writer) error {
+		return nbt.Marshal(nbt.GZip, w, player)
+	})
 }
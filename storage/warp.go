@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type Warp struct {
+	X, Y, Z    float64
+	Yaw, Pitch float32
+}
+
+var (
+	warps       map[string]Warp // Keyed by lowercase name
+	warpLock    sync.Mutex
+	warpsLoaded bool
+)
+
+// The caller must hold warpLock.
+func loadWarps() {
+	if warpsLoaded {
+		return
+	}
+	warpsLoaded = true
+	warps = make(map[string]Warp)
+
+	f, err := os.Open("world/warps.json")
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Print("While trying to load warps.json: ", err)
+		}
+		return
+	}
+	defer f.Close()
+
+	if err = json.NewDecoder(f).Decode(&warps); err != nil {
+		log.Print("While trying to load warps.json: ", err)
+	}
+}
+
+// The caller must hold warpLock.
+func saveWarps() error {
+	data, err := json.MarshalIndent(warps, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create("world/warps.json")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(data)
+	return err
+}
+
+func GetWarp(name string) (Warp, bool) {
+	warpLock.Lock()
+	defer warpLock.Unlock()
+
+	loadWarps()
+	warp, ok := warps[strings.ToLower(name)]
+	return warp, ok
+}
+
+// Creates or moves a warp and saves the warp list.
+func SetWarp(name string, warp Warp) error {
+	warpLock.Lock()
+	defer warpLock.Unlock()
+
+	loadWarps()
+	warps[strings.ToLower(name)] = warp
+	return saveWarps()
+}
+
+// Returns false if there was no warp with that name.
+func DeleteWarp(name string) (bool, error) {
+	warpLock.Lock()
+	defer warpLock.Unlock()
+
+	loadWarps()
+	if _, ok := warps[strings.ToLower(name)]; !ok {
+		return false, nil
+	}
+	delete(warps, strings.ToLower(name))
+	return true, saveWarps()
+}
+
+// Returns the name of every warp, sorted.
+func Warps() []string {
+	warpLock.Lock()
+	defer warpLock.Unlock()
+
+	loadWarps()
+	names := make([]string, 0, len(warps))
+	for name := range warps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}